@@ -0,0 +1,65 @@
+// Package buildinfo describes the build of the binary. Its version, commit and date are
+// injected at link time, such as:
+//
+//	go build -ldflags "-X github.com/s1moe2/gosrv/buildinfo.Version=v1.2.0 -X github.com/s1moe2/gosrv/buildinfo.Commit=$(git rev-parse HEAD)"
+//
+// The commit and date default to those Go records from version control, when available.
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at link time with -ldflags -X
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// Info describes the build of the binary
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build information of the binary
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version()}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, s := range build.Settings {
+		switch {
+		case s.Key == "vcs.revision" && info.Commit == "":
+			info.Commit = s.Value
+		case s.Key == "vcs.time" && info.Date == "":
+			info.Date = s.Value
+		case s.Key == "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
+
+func (i Info) String() string {
+	s := "gosrv " + i.Version
+	if i.Commit != "" {
+		s += fmt.Sprintf(" (%s", i.Commit)
+		if i.Modified {
+			s += ", modified"
+		}
+		s += ")"
+	}
+	if i.Date != "" {
+		s += " built " + i.Date
+	}
+	return s + " with " + i.GoVersion
+}
@@ -0,0 +1,25 @@
+package buildinfo
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	defer func(v, c, d string) { Version, Commit, Date = v, c, d }(Version, Commit, Date)
+	Version, Commit, Date = "v1.2.0", "abc123", "2024-01-02T03:04:05Z"
+
+	info := Get()
+	if info.Version != "v1.2.0" || info.Commit != "abc123" || info.Date != "2024-01-02T03:04:05Z" {
+		t.Fatalf("expected the injected values, got %+v", info)
+	}
+	if info.GoVersion != runtime.Version() {
+		t.Fatalf("expected Go version %s, got %s", runtime.Version(), info.GoVersion)
+	}
+
+	s := info.String()
+	if !strings.HasPrefix(s, "gosrv v1.2.0 (abc123") || !strings.Contains(s, "built 2024-01-02T03:04:05Z") {
+		t.Fatalf("unexpected string %q", s)
+	}
+}
@@ -2,36 +2,88 @@ package config
 
 import "time"
 
+// Configuration fields are described by struct tags:
+//   - key is the name of the field in the configuration file, within its section
+//   - env is the environment variable setting it, which also names its command-line flag
+//   - default is its value when no source sets it
+//   - oneof lists the values it accepts, if limited
+//   - secret marks values redacted when the configuration is printed
+//   - reload marks values applied when the configuration is reloaded, on SIGHUP
+//   - usage describes it in the command-line help
+
 type ServerConfig struct {
-	Address        string
-	HandlerTimeout time.Duration
-	ReadTimeout    time.Duration
-	WriteTimeout   time.Duration
-	IdleTimeout    time.Duration
+	Address        string        `key:"address" env:"ADDRESS" default:"localhost:4000" usage:"address the API listens on"`
+	HandlerTimeout time.Duration `key:"handler_timeout" env:"HANDLER_TIMEOUT" default:"30s" reload:"true" usage:"time allowed to handle a request"`
+	ReadTimeout    time.Duration `key:"read_timeout" env:"READ_TIMEOUT" default:"10s" usage:"time allowed to read a request"`
+	WriteTimeout   time.Duration `key:"write_timeout" env:"WRITE_TIMEOUT" default:"20s" usage:"time allowed to write a response"`
+	IdleTimeout    time.Duration `key:"idle_timeout" env:"IDLE_TIMEOUT" default:"30s" usage:"time a keep-alive connection may stay idle"`
+	CursorSecret   string        `key:"cursor_secret" env:"CURSOR_SECRET" secret:"true" usage:"secret signing pagination cursors, random when empty"`
+	// ReadinessTimeout bounds each check of the readiness endpoint
+	ReadinessTimeout time.Duration `key:"readiness_timeout" env:"READINESS_TIMEOUT" default:"2s" usage:"time allowed to each readiness check"`
+	// ShutdownDelay is how long the server keeps serving, while reported as not ready,
+	// before shutting down, so that it can be taken out of rotation first
+	ShutdownDelay time.Duration `key:"shutdown_delay" env:"SHUTDOWN_DELAY" default:"0s" usage:"time to keep serving once shutdown begins"`
+	// MaxBodySize bounds the size of request bodies, larger ones being rejected with 413
+	MaxBodySize int `key:"max_body_size" env:"MAX_BODY_SIZE" default:"1048576" usage:"maximum size of request bodies, in bytes"`
+	// AdminAddress is the address of the listener serving profiling, metrics and debug endpoints,
+	// which moves metrics off Address. The admin listener is disabled when empty.
+	AdminAddress string `key:"admin_address" env:"ADMIN_ADDRESS" usage:"address of the admin listener serving pprof, metrics and debug endpoints, disabled when empty"`
+	// CORSOrigins are the origins browsers may call the API from, * allowing any
+	CORSOrigins []string `key:"cors_origins" env:"CORS_ORIGINS" reload:"true" usage:"comma-separated origins allowed to make cross-origin requests, * for any"`
+	// RateLimit is the number of requests per second allowed to each client IP, 0 disabling
+	// the limit, with bursts of up to RateBurst requests
+	RateLimit float64 `key:"rate_limit" env:"RATE_LIMIT" default:"0" reload:"true" usage:"requests per second allowed to each client IP, 0 for no limit"`
+	RateBurst int     `key:"rate_burst" env:"RATE_BURST" default:"20" reload:"true" usage:"requests a client IP may burst above the rate limit"`
+	// Development re-raises the panics of handlers once logged, instead of responding 500,
+	// so that they can't go unnoticed
+	Development bool `key:"development" env:"DEVELOPMENT" default:"false" usage:"development mode, re-raising the panics of handlers"`
+	// Features are the names of the feature flags enabled
+	Features []string `key:"features" env:"FEATURES" reload:"true" usage:"comma-separated feature flags to enable"`
+	// TimeoutStatus is the status of the requests running out of HandlerTimeout, 504 suiting
+	// APIs behind a gateway that tells it apart from the API being unavailable
+	TimeoutStatus int `key:"timeout_status" env:"TIMEOUT_STATUS" default:"503" oneof:"503 504" reload:"true" usage:"status of requests running out of the handler timeout, 503 or 504"`
+}
+
+type TLSConfig struct {
+	// CertFile and KeyFile enable HTTPS when set, and are reloaded when they change on disk
+	CertFile string `key:"cert_file" env:"TLS_CERT_FILE" usage:"PEM certificate file, serving HTTPS when set"`
+	KeyFile  string `key:"key_file" env:"TLS_KEY_FILE" usage:"PEM private key file of the certificate"`
+	// ReloadInterval is how often the certificate and key files are checked for changes
+	ReloadInterval time.Duration `key:"reload_interval" env:"TLS_RELOAD_INTERVAL" default:"10s" usage:"time between checks of the certificate files for changes"`
+	MinVersion     string        `key:"min_version" env:"TLS_MIN_VERSION" default:"1.2" oneof:"1.0 1.1 1.2 1.3" usage:"minimum TLS version"`
+	// CipherSuites are the names of the cipher suites allowed below TLS 1.3, the Go defaults when empty
+	CipherSuites []string `key:"cipher_suites" env:"TLS_CIPHER_SUITES" usage:"comma-separated cipher suites allowed below TLS 1.3, such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"`
+	// ClientCAFile requires clients to present a certificate signed by one of its CAs (mutual TLS)
+	ClientCAFile string `key:"client_ca_file" env:"TLS_CLIENT_CA_FILE" usage:"PEM CA bundle client certificates are verified against, requiring them when set"`
 }
 
 type DatabaseConfig struct {
-	URI    string
-	Driver string
+	URI    string `key:"uri" env:"DB_URI" secret:"true" usage:"database connection URI, or file path for sqlite and memory"`
+	Driver string `key:"driver" env:"DB_DRIVER" default:"postgres" oneof:"postgres sqlite memory" usage:"database driver"`
+	// AutoMigrate applies pending migrations when the server starts
+	AutoMigrate bool `key:"auto_migrate" env:"DB_AUTO_MIGRATE" default:"false" usage:"apply pending migrations on startup"`
 }
 
-type AppConfig struct {
-	Server   ServerConfig
-	Database DatabaseConfig
+type LogConfig struct {
+	// Level is the minimum level logged: debug, info, warn or error
+	Level string `key:"level" env:"LOG_LEVEL" default:"info" oneof:"debug info warn error" reload:"true" usage:"minimum log level"`
+	// Format is the format of application logs: json or logfmt
+	Format string `key:"format" env:"LOG_FORMAT" default:"json" oneof:"json logfmt" usage:"log format"`
+	// AccessFormat is the format of access logs: json, logfmt or combined, defaulting to Format
+	AccessFormat string `key:"access_format" env:"ACCESS_LOG_FORMAT" oneof:"json logfmt combined" usage:"access log format, the log format by default"`
 }
 
-func New() *AppConfig {
-	return &AppConfig{
-		Server: ServerConfig{
-			Address:        getEnv("ADDRESS", "localhost:4000"),
-			HandlerTimeout: getEnvAsDuration("HANDLER_TIMEOUT", 30),
-			ReadTimeout:    getEnvAsDuration("READ_TIMEOUT", 10),
-			WriteTimeout:   getEnvAsDuration("WRITE_TIMEOUT", 20),
-			IdleTimeout:    getEnvAsDuration("IDLE_TIMEOUT", 30),
-		},
-		Database: DatabaseConfig{
-			URI:    getEnv("DB_URI", ""),
-			Driver: getEnv("DB_DRIVER", "postgres"),
-		},
-	}
+type TracingConfig struct {
+	// Exporter is where spans are exported to: none, stdout or otlp
+	Exporter string `key:"exporter" env:"TRACE_EXPORTER" default:"none" oneof:"none stdout otlp" usage:"trace exporter"`
+	// File is the file the stdout exporter writes to, stdout when empty
+	File string `key:"file" env:"TRACE_FILE" usage:"file the stdout trace exporter appends to"`
+}
+
+type AppConfig struct {
+	Server   ServerConfig   `key:"server"`
+	TLS      TLSConfig      `key:"tls"`
+	Database DatabaseConfig `key:"database"`
+	Log      LogConfig      `key:"log"`
+	Tracing  TracingConfig  `key:"tracing"`
 }
@@ -0,0 +1,422 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileEnv is the environment variable naming the configuration file,
+// which the --config flag overrides
+const ConfigFileEnv = "CONFIG_FILE"
+
+// redacted replaces the value of secrets when the configuration is printed
+const redacted = "REDACTED"
+
+// ValidationError lists every invalid value found while loading the configuration
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Errors, "\n  ")
+}
+
+// field is a configuration field along with its tags
+type field struct {
+	section string
+	key     string
+	env     string
+	def     string
+	oneof   []string
+	secret  bool
+	reload  bool
+	usage   string
+	value   reflect.Value
+}
+
+// path returns the name of the field in the configuration file, such as server.address
+func (f field) path() string {
+	return f.section + "." + f.key
+}
+
+// flagName returns the name of the command-line flag of the field, such as db-uri
+func (f field) flagName() string {
+	return strings.ReplaceAll(strings.ToLower(f.env), "_", "-")
+}
+
+// fields returns the fields of a configuration, bound to its values
+func fields(conf *AppConfig) []field {
+	var fs []field
+
+	sections := reflect.ValueOf(conf).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Type().Field(i).Tag.Get("key")
+		values := sections.Field(i)
+
+		for j := 0; j < values.NumField(); j++ {
+			tag := values.Type().Field(j).Tag
+			f := field{
+				section: section,
+				key:     tag.Get("key"),
+				env:     tag.Get("env"),
+				def:     tag.Get("default"),
+				secret:  tag.Get("secret") == "true",
+				reload:  tag.Get("reload") == "true",
+				usage:   tag.Get("usage"),
+				value:   values.Field(j),
+			}
+			if oneof := tag.Get("oneof"); oneof != "" {
+				f.oneof = strings.Fields(oneof)
+			}
+			fs = append(fs, f)
+		}
+	}
+
+	return fs
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// set parses a raw value into the field
+func (f field) set(raw string) error {
+	switch {
+	case f.value.Type() == durationType:
+		d, err := parseDuration(raw)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(d))
+	case f.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		f.value.SetBool(b)
+	case f.value.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid number %q, expected a positive integer", raw)
+		}
+		if err := f.checkOneof(strconv.Itoa(n)); err != nil {
+			return err
+		}
+		f.value.SetInt(int64(n))
+	case f.value.Kind() == reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid number %q, expected a positive number", raw)
+		}
+		if err := f.checkOneof(strconv.FormatFloat(n, 'f', -1, 64)); err != nil {
+			return err
+		}
+		f.value.SetFloat(n)
+	case f.value.Kind() == reflect.Slice:
+		f.value.Set(reflect.ValueOf(splitList(raw)))
+	default:
+		// fields without a default can be left empty, meaning they are derived from others
+		if raw != "" || f.def != "" {
+			if err := f.checkOneof(raw); err != nil {
+				return err
+			}
+		}
+		f.value.SetString(raw)
+	}
+	return nil
+}
+
+// checkOneof checks that a value is one of those allowed for the field, if restricted
+func (f field) checkOneof(value string) error {
+	if f.oneof != nil && !contains(f.oneof, value) {
+		return fmt.Errorf("invalid value %q, expected one of %s", value, strings.Join(f.oneof, ", "))
+	}
+	return nil
+}
+
+// format returns the value of the field as it would be written in a configuration file
+func (f field) format() interface{} {
+	switch {
+	case f.value.Type() == durationType:
+		return time.Duration(f.value.Int()).String()
+	case f.value.Kind() == reflect.Bool:
+		return f.value.Bool()
+	case f.value.Kind() == reflect.Int:
+		return f.value.Int()
+	case f.value.Kind() == reflect.Float64:
+		return f.value.Float()
+	case f.value.Kind() == reflect.Slice:
+		return f.value.Interface()
+	default:
+		if f.secret && f.value.String() != "" {
+			return redacted
+		}
+		return f.value.String()
+	}
+}
+
+// parseDuration parses a Go duration string, or a number of seconds as accepted before
+func parseDuration(raw string) (time.Duration, error) {
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		seconds, atoiErr := strconv.Atoi(raw)
+		if atoiErr != nil {
+			return 0, fmt.Errorf("invalid duration %q, expected a value such as 10s or 1m30s", raw)
+		}
+		d = time.Duration(seconds) * time.Second
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid duration %q, must not be negative", raw)
+	}
+	return d, nil
+}
+
+// splitList parses a comma-separated list, ignoring blank items
+func splitList(raw string) []string {
+	list := []string{}
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Load builds the configuration from, in increasing order of precedence: the defaults,
+// the configuration file, environment variables and command-line flags. Environment
+// variables can be read from a file by appending _FILE to their name, as is usual for
+// secrets. It returns the arguments left once flags are parsed, and fails listing every
+// invalid value found.
+func Load(args []string) (*AppConfig, []string, error) {
+	conf := &AppConfig{}
+	fs := fields(conf)
+	var errs []string
+
+	for _, f := range fs {
+		if err := f.set(f.def); err != nil {
+			panic(fmt.Sprintf("config: invalid default for %s: %s", f.path(), err))
+		}
+	}
+
+	flags, configFile, err := parseFlags(fs, args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if configFile == "" {
+		configFile = os.Getenv(ConfigFileEnv)
+	}
+	if configFile != "" {
+		errs = append(errs, loadFile(fs, configFile)...)
+	}
+
+	for _, f := range fs {
+		raw, ok, err := lookupEnv(f.env)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if ok {
+			if err := f.set(raw); err != nil {
+				errs = append(errs, fmt.Sprintf("env %s: %s", f.env, err))
+			}
+		}
+	}
+
+	for _, f := range fs {
+		if raw, ok := flags.values[f.flagName()]; ok {
+			if err := f.set(raw); err != nil {
+				errs = append(errs, fmt.Sprintf("flag --%s: %s", f.flagName(), err))
+			}
+		}
+	}
+
+	if errs != nil {
+		return nil, nil, &ValidationError{Errors: errs}
+	}
+
+	if conf.Log.AccessFormat == "" {
+		conf.Log.AccessFormat = conf.Log.Format
+	}
+	return conf, flags.args, nil
+}
+
+// parsedFlags holds the flags set on the command line, by name
+type parsedFlags struct {
+	values map[string]string
+	args   []string
+}
+
+// newFlagSet returns the flags of every field, along with --config naming the configuration file
+func newFlagSet(fs []field) (*flag.FlagSet, *string) {
+	set := flag.NewFlagSet("gosrv", flag.ContinueOnError)
+	set.SetOutput(io.Discard)
+
+	configFile := set.String("config", "", "configuration file, YAML or TOML (env "+ConfigFileEnv+")")
+	for _, f := range fs {
+		set.String(f.flagName(), f.def, f.usage+" (env "+f.env+")")
+	}
+	return set, configFile
+}
+
+// parseFlags parses the command-line flags, returning the configuration file they name
+func parseFlags(fs []field, args []string) (parsedFlags, string, error) {
+	set, configFile := newFlagSet(fs)
+	if err := set.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return parsedFlags{}, "", fmt.Errorf("usage: gosrv [flags] [command]\n\nflags:\n%s", Usage())
+		}
+		return parsedFlags{}, "", err
+	}
+
+	parsed := parsedFlags{values: map[string]string{}, args: set.Args()}
+	set.Visit(func(fl *flag.Flag) {
+		if fl.Name != "config" {
+			parsed.values[fl.Name] = fl.Value.String()
+		}
+	})
+	return parsed, *configFile, nil
+}
+
+// Usage returns the help text of the command-line flags
+func Usage() string {
+	var b strings.Builder
+	set, _ := newFlagSet(fields(&AppConfig{}))
+	set.SetOutput(&b)
+	set.PrintDefaults()
+	return b.String()
+}
+
+// lookupEnv returns the value of an environment variable, or the content of the
+// file named by its _FILE variant, which can't both be set
+func lookupEnv(name string) (string, bool, error) {
+	value, ok := os.LookupEnv(name)
+	path, fileOK := os.LookupEnv(name + "_FILE")
+	if !fileOK {
+		return value, ok, nil
+	}
+	if ok {
+		return "", false, fmt.Errorf("env %s: can't be set along with %s_FILE", name, name)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("env %s_FILE: %s", name, err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), true, nil
+}
+
+// loadFile sets the fields found in a YAML or TOML configuration file, picked by extension
+func loadFile(fs []field, path string) []string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return []string{fmt.Sprintf("file: %s", err)}
+	}
+
+	doc := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &doc)
+	case ".toml":
+		err = toml.Unmarshal(content, &doc)
+	default:
+		return []string{fmt.Sprintf("file %s: unsupported format, expected .yaml, .yml or .toml", path)}
+	}
+	if err != nil {
+		return []string{fmt.Sprintf("file %s: %s", path, err)}
+	}
+
+	byPath := map[string]field{}
+	for _, f := range fs {
+		byPath[f.path()] = f
+	}
+
+	var errs []string
+	for _, section := range sortedKeys(doc) {
+		values, ok := doc[section].(map[string]interface{})
+		if !ok {
+			errs = append(errs, fmt.Sprintf("file %s: %s: expected a section", path, section))
+			continue
+		}
+
+		for _, key := range sortedKeys(values) {
+			f, ok := byPath[section+"."+key]
+			if !ok {
+				errs = append(errs, fmt.Sprintf("file %s: %s.%s: unknown setting", path, section, key))
+				continue
+			}
+			if err := f.set(fileValue(values[key])); err != nil {
+				errs = append(errs, fmt.Sprintf("file %s: %s: %s", path, f.path(), err))
+			}
+		}
+	}
+	return errs
+}
+
+// fileValue returns a value read from a configuration file as it would be written in
+// an environment variable, lists being comma-separated
+func fileValue(value interface{}) string {
+	list, ok := value.([]interface{})
+	if !ok {
+		return fmt.Sprint(value)
+	}
+	items := make([]string, len(list))
+	for i, item := range list {
+		items[i] = fmt.Sprint(item)
+	}
+	return strings.Join(items, ",")
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Print writes the configuration to w as YAML, redacting secrets, so that it can
+// be checked or used as a configuration file
+func Print(conf *AppConfig, w io.Writer) error {
+	doc := yaml.Node{Kind: yaml.MappingNode}
+	var section *yaml.Node
+
+	for _, f := range fields(conf) {
+		if section == nil || doc.Content[len(doc.Content)-2].Value != f.section {
+			section = &yaml.Node{Kind: yaml.MappingNode}
+			doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: f.section}, section)
+		}
+
+		value := &yaml.Node{}
+		if err := value.Encode(f.format()); err != nil {
+			return err
+		}
+		section.Content = append(section.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: f.key}, value)
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
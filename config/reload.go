@@ -0,0 +1,26 @@
+package config
+
+import "reflect"
+
+// Reloaded returns a copy of current with the settings marked as reloadable taken from
+// next, which should have been loaded and validated with Load. It also returns the paths
+// of the settings that changed, telling apart those applied from those that only take
+// effect on restart.
+func Reloaded(current, next *AppConfig) (conf *AppConfig, applied, ignored []string) {
+	merged := *current
+	nextFields := fields(next)
+
+	for i, f := range fields(&merged) {
+		nf := nextFields[i]
+		if reflect.DeepEqual(f.value.Interface(), nf.value.Interface()) {
+			continue
+		}
+		if !f.reload {
+			ignored = append(ignored, f.path())
+			continue
+		}
+		f.value.Set(nf.value)
+		applied = append(applied, f.path())
+	}
+	return &merged, applied, ignored
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReloaded(t *testing.T) {
+	current, _, err := Load(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	setenv(t, "LOG_LEVEL", "debug", "CORS_ORIGINS", "*", "ADDRESS", "localhost:5000")
+	next, _, err := Load(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	conf, applied, ignored := Reloaded(current, next)
+	if conf.Log.Level != "debug" || len(conf.Server.CORSOrigins) != 1 {
+		t.Fatalf("expected the reloadable settings to be applied, got %+v", conf)
+	}
+	if conf.Server.Address != "localhost:4000" || conf.Server.HandlerTimeout != 30*time.Second {
+		t.Fatalf("expected the other settings to be kept, got %+v", conf.Server)
+	}
+	if current.Log.Level != "info" {
+		t.Fatal("expected the current configuration to be left untouched")
+	}
+
+	if strings.Join(applied, " ") != "server.cors_origins log.level" {
+		t.Fatalf("unexpected applied settings %v", applied)
+	}
+	if strings.Join(ignored, " ") != "server.address" {
+		t.Fatalf("unexpected ignored settings %v", ignored)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	_ "github.com/lib/pq"
 	"github.com/pkg/errors"
 	"github.com/s1moe2/gosrv/config"
+	_ "modernc.org/sqlite"
 )
 
 // ConnectDB opens a connection to the database.
@@ -14,6 +15,11 @@ func ConnectDB(dbConfig config.DatabaseConfig) (*sqlx.DB, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to open db connection")
 	}
+	if dbConfig.Driver == "sqlite" {
+		// SQLite serializes writers, a single connection avoids SQLITE_BUSY errors
+		// and keeps in-memory databases from being opened once per connection
+		db.SetMaxOpenConns(1)
+	}
 	if err = db.Ping(); err != nil {
 		return nil, errors.Wrap(err, "failed to ping db")
 	}
@@ -0,0 +1,345 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// migrationLockID is the key of the Postgres advisory lock held while migrating,
+// so that instances starting together don't run the same migrations twice
+const migrationLockID = 4242424242
+
+// migrationFile matches the names of migration files, as created by CreateMigration
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is a schema change, made of the files that apply and revert it
+type Migration struct {
+	Version uint64
+	Name    string
+	up      string
+	down    string
+}
+
+// MigrationStatus tells whether a migration has been applied to the database
+type MigrationStatus struct {
+	Migration
+	Applied bool
+}
+
+// Migrator applies migrations to a database, keeping track of the current version in a
+// schema_migrations table laid out like golang-migrate's, so databases migrated by it
+// carry on from where they were
+type Migrator struct {
+	db         *sqlx.DB
+	source     fs.FS
+	migrations []Migration
+}
+
+// NewMigrator returns a Migrator applying the migrations found in source
+func NewMigrator(db *sqlx.DB, source fs.FS) (*Migrator, error) {
+	files, err := fs.ReadDir(source, ".")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read migrations")
+	}
+
+	byVersion := map[uint64]*Migration{}
+	for _, file := range files {
+		match := migrationFile.FindStringSubmatch(file.Name())
+		if file.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("invalid migration version in %s", file.Name())
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d is used by both %s and %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.up = file.Name()
+		} else {
+			m.down = file.Name()
+		}
+	}
+
+	migrator := &Migrator{db: db, source: source}
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d_%s is missing its up or down file", m.Version, m.Name)
+		}
+		migrator.migrations = append(migrator.migrations, *m)
+	}
+	sort.Slice(migrator.migrations, func(i, j int) bool {
+		return migrator.migrations[i].Version < migrator.migrations[j].Version
+	})
+
+	return migrator, nil
+}
+
+// Up applies the next n pending migrations, or all of them if n is not positive
+func (m *Migrator) Up(ctx context.Context, n int) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		idx, err := m.currentIndex(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		target := len(m.migrations) - 1
+		if n > 0 && idx+n < target {
+			target = idx + n
+		}
+		return m.migrate(ctx, conn, idx, target)
+	})
+}
+
+// Down reverts the last n applied migrations, or a single one if n is not positive
+func (m *Migrator) Down(ctx context.Context, n int) error {
+	if n <= 0 {
+		n = 1
+	}
+
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		idx, err := m.currentIndex(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		target := idx - n
+		if target < -1 {
+			target = -1
+		}
+		return m.migrate(ctx, conn, idx, target)
+	})
+}
+
+// Goto migrates up or down to the given version, zero meaning before the first migration
+func (m *Migrator) Goto(ctx context.Context, version uint64) error {
+	target := m.index(version)
+	if version != 0 && target < 0 {
+		return fmt.Errorf("no migration with version %d", version)
+	}
+
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		idx, err := m.currentIndex(ctx, conn)
+		if err != nil {
+			return err
+		}
+		return m.migrate(ctx, conn, idx, target)
+	})
+}
+
+// Status returns every known migration and whether it has been applied. Like Pending, it
+// only reads the schema table, neither waiting for the migration lock nor creating it.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	idx, err := m.readIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for i, migration := range m.migrations {
+		statuses = append(statuses, MigrationStatus{Migration: migration, Applied: i <= idx})
+	}
+	return statuses, nil
+}
+
+// Pending returns the number of migrations that haven't been applied yet. Unlike the
+// other methods, it neither waits for the migration lock nor creates the schema table.
+func (m *Migrator) Pending(ctx context.Context) (int, error) {
+	idx, err := m.readIndex(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return len(m.migrations) - 1 - idx, nil
+}
+
+// readIndex returns the index of the last applied migration without locking, or -1 if none
+// is, including when the schema table doesn't exist yet
+func (m *Migrator) readIndex(ctx context.Context) (int, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get a db connection")
+	}
+	defer conn.Close()
+
+	stmt := "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'"
+	if m.db.DriverName() == "postgres" {
+		stmt = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'schema_migrations'"
+	}
+	var tables int
+	if err := conn.QueryRowContext(ctx, stmt).Scan(&tables); err != nil {
+		return 0, errors.Wrap(err, "failed to look up schema_migrations table")
+	}
+	if tables == 0 {
+		return -1, nil
+	}
+
+	return m.currentIndex(ctx, conn)
+}
+
+// withLock runs fn on a single connection, holding the migration lock on Postgres,
+// after making sure the schema_migrations table exists
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to get a db connection")
+	}
+	defer conn.Close()
+
+	// advisory locks belong to the session, so they must be taken and released on the same connection
+	if m.db.DriverName() == "postgres" {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+			return errors.Wrap(err, "failed to acquire migration lock")
+		}
+		defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+	}
+
+	stmt := "CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL)"
+	if _, err := conn.ExecContext(ctx, stmt); err != nil {
+		return errors.Wrap(err, "failed to create schema_migrations table")
+	}
+
+	return fn(conn)
+}
+
+// currentIndex returns the index of the last applied migration, or -1 if none is
+func (m *Migrator) currentIndex(ctx context.Context, conn *sql.Conn) (int, error) {
+	var version uint64
+	var dirty bool
+
+	err := conn.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if err == sql.ErrNoRows {
+		return -1, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read schema version")
+	}
+	if dirty {
+		return 0, fmt.Errorf("database is dirty at version %d, fix it by hand and reset schema_migrations", version)
+	}
+
+	idx := m.index(version)
+	if idx < 0 {
+		return 0, fmt.Errorf("database is at version %d, which has no migration", version)
+	}
+	return idx, nil
+}
+
+// index returns the position of a migration version, or -1 if it doesn't exist
+func (m *Migrator) index(version uint64) int {
+	for i, migration := range m.migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// migrate applies or reverts migrations one at a time, each in its own transaction
+// along with the schema version change, from the from index to the target one
+func (m *Migrator) migrate(ctx context.Context, conn *sql.Conn, from, target int) error {
+	for idx := from; idx < target; idx++ {
+		next := m.migrations[idx+1]
+		if err := m.apply(ctx, conn, next.up, next.Version); err != nil {
+			return err
+		}
+	}
+
+	for idx := from; idx > target; idx-- {
+		var version uint64
+		if idx > 0 {
+			version = m.migrations[idx-1].Version
+		}
+		if err := m.apply(ctx, conn, m.migrations[idx].down, version); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// apply runs a migration file and sets the schema version, zero clearing it
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, file string, version uint64) error {
+	stmt, err := fs.ReadFile(m.source, file)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %s", file)
+	}
+
+	slog.Info("running migration", "file", file)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, string(stmt)); err != nil {
+		return errors.Wrapf(err, "failed to run %s", file)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations"); err != nil {
+		return errors.Wrap(err, "failed to update schema version")
+	}
+	if version > 0 {
+		stmt := m.db.Rebind("INSERT INTO schema_migrations (version, dirty) VALUES (?, ?)")
+		if _, err := tx.ExecContext(ctx, stmt, version, false); err != nil {
+			return errors.Wrap(err, "failed to update schema version")
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CreateMigration creates empty up and down files for a new migration in dir,
+// numbered after the last migration found there, and returns their paths
+func CreateMigration(dir, name string) ([]string, error) {
+	name = strings.Join(strings.Fields(strings.ToLower(name)), "-")
+	if name == "" {
+		return nil, errors.New("migration name can't be empty")
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read migrations dir")
+	}
+
+	var last uint64
+	for _, file := range files {
+		if match := migrationFile.FindStringSubmatch(file.Name()); match != nil {
+			if version, err := strconv.ParseUint(match[1], 10, 64); err == nil && version > last {
+				last = version
+			}
+		}
+	}
+
+	var paths []string
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(dir, fmt.Sprintf("%06d_%s.%s.sql", last+1, name, direction))
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create migration file")
+		}
+		file.Close()
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,233 @@
+package db
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/s1moe2/gosrv/migrations"
+)
+
+func newSqliteMigrator(t *testing.T) (*Migrator, *sqlx.DB) {
+	db, err := sqlx.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	source, err := migrations.FS("sqlite")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	migrator, err := NewMigrator(db, source)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return migrator, db
+}
+
+func appliedVersions(t *testing.T, migrator *Migrator) []uint64 {
+	statuses, err := migrator.Status(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	versions := []uint64{}
+	for _, s := range statuses {
+		if s.Applied {
+			versions = append(versions, s.Version)
+		}
+	}
+	return versions
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	migrator, db := newSqliteMigrator(t)
+
+	if err := migrator.Up(ctx, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v := appliedVersions(t, migrator); !reflect.DeepEqual(v, []uint64{1}) {
+		t.Fatalf("expected [1] to be applied, got %v", v)
+	}
+
+	if err := migrator.Up(ctx, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v := appliedVersions(t, migrator); !reflect.DeepEqual(v, []uint64{1, 2, 3}) {
+		t.Fatalf("expected [1 2 3] to be applied, got %v", v)
+	}
+	if _, err := db.Exec("INSERT INTO users (name, email, deleted_at) VALUES ('ann', 'ann@gosrv.com', NULL)"); err != nil {
+		t.Fatalf("expected the schema to be migrated, got %v", err)
+	}
+
+	if err := migrator.Down(ctx, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v := appliedVersions(t, migrator); !reflect.DeepEqual(v, []uint64{1, 2}) {
+		t.Fatalf("expected [1 2] to be applied, got %v", v)
+	}
+	var user struct {
+		ID      int64
+		Email   string
+		Version int64
+	}
+	if err := db.Get(&user, "SELECT id, email, version FROM users"); err != nil || user.ID != 1 || user.Email != "ann@gosrv.com" {
+		t.Fatalf("expected the rolled back table to keep its rows, got %+v (%v)", user, err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name, email) VALUES ('ann', 'ann@gosrv.com')"); err == nil {
+		t.Fatal("expected the rolled back table to keep its unique email index")
+	}
+
+	if err := migrator.Down(ctx, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.Exec("SELECT version FROM users"); err == nil {
+		t.Fatal("expected the version column to be dropped")
+	}
+	if err := migrator.Up(ctx, 0); err != nil {
+		t.Fatalf("expected the migrations to apply again, got %v", err)
+	}
+
+	if err := migrator.Goto(ctx, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v := appliedVersions(t, migrator); len(v) != 0 {
+		t.Fatalf("expected no migration to be applied, got %v", v)
+	}
+
+	if err := migrator.Goto(ctx, 9); err == nil {
+		t.Fatal("expected an error going to an unknown version")
+	}
+}
+
+func TestMigrator_Dirty(t *testing.T) {
+	migrator, db := newSqliteMigrator(t)
+	if err := migrator.Up(context.Background(), 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// golang-migrate leaves the version dirty when a migration fails halfway
+	if _, err := db.Exec("UPDATE schema_migrations SET dirty = true"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := migrator.Up(context.Background(), 0); err == nil {
+		t.Fatal("expected an error migrating a dirty database")
+	}
+}
+
+func TestMigrator_FailedMigration(t *testing.T) {
+	db, err := sqlx.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	migrator, err := NewMigrator(db, fstest.MapFS{
+		"000001_ok.up.sql":     {Data: []byte("CREATE TABLE a (id INTEGER);")},
+		"000001_ok.down.sql":   {Data: []byte("DROP TABLE a;")},
+		"000002_fail.up.sql":   {Data: []byte("CREATE TABLE b (id INTEGER); NOT SQL;")},
+		"000002_fail.down.sql": {Data: []byte("DROP TABLE b;")},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := migrator.Up(context.Background(), 0); err == nil {
+		t.Fatal("expected the failing migration to be reported")
+	}
+	if v := appliedVersions(t, migrator); !reflect.DeepEqual(v, []uint64{1}) {
+		t.Fatalf("expected only [1] to be applied, got %v", v)
+	}
+
+	var tables int
+	_ = db.Get(&tables, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'b'")
+	if tables != 0 {
+		t.Fatal("expected the failing migration to be rolled back")
+	}
+}
+
+func TestNewMigrator_MissingDown(t *testing.T) {
+	_, err := NewMigrator(nil, fstest.MapFS{
+		"000001_ok.up.sql": {Data: []byte("CREATE TABLE a (id INTEGER);")},
+	})
+	if err == nil {
+		t.Fatal("expected an error for a migration without a down file")
+	}
+}
+
+func TestCreateMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosrv")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"000001_create-users.up.sql", "000001_create-users.down.sql", "README.md"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	paths, err := CreateMigration(dir, "Add Users Index")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{
+		filepath.Join(dir, "000002_add-users-index.up.sql"),
+		filepath.Join(dir, "000002_add-users-index.down.sql"),
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("expected %v, got %v", expected, paths)
+	}
+}
+
+func TestMigrator_Pending(t *testing.T) {
+	ctx := context.Background()
+	migrator, _ := newSqliteMigrator(t)
+
+	if pending, err := migrator.Pending(ctx); err != nil || pending != 3 {
+		t.Fatalf("expected 3 pending migrations before the schema table exists, got %d (%v)", pending, err)
+	}
+
+	if err := migrator.Up(ctx, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pending, err := migrator.Pending(ctx); err != nil || pending != 2 {
+		t.Fatalf("expected 2 pending migrations, got %d (%v)", pending, err)
+	}
+
+	if err := migrator.Up(ctx, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pending, err := migrator.Pending(ctx); err != nil || pending != 0 {
+		t.Fatalf("expected no pending migrations, got %d (%v)", pending, err)
+	}
+}
+
+func TestMigrator_StatusReadOnly(t *testing.T) {
+	migrator, db := newSqliteMigrator(t)
+
+	statuses, err := migrator.Status(context.Background())
+	if err != nil || len(statuses) != 3 {
+		t.Fatalf("expected the 3 migrations, got %v (%v)", statuses, err)
+	}
+	for _, s := range statuses {
+		if s.Applied {
+			t.Fatalf("expected no migration to be applied, got %+v", s)
+		}
+	}
+
+	var tables int
+	if err := db.Get(&tables, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'schema_migrations'"); err != nil || tables != 0 {
+		t.Fatalf("expected the schema table not to be created, got %d (%v)", tables, err)
+	}
+}
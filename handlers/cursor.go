@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"strings"
+
+	"github.com/s1moe2/gosrv/models"
+)
+
+var errInvalidCursor = errors.New("cursor: invalid or tampered")
+
+// cursor is the position of a keyset paginated listing handed out to clients
+type cursor struct {
+	Sort     string `json:"s"`
+	Desc     bool   `json:"d,omitempty"`
+	Value    string `json:"v,omitempty"`
+	ID       string `json:"i"`
+	Backward bool   `json:"b,omitempty"`
+}
+
+// cursorCodec turns cursors into opaque tokens signed with HMAC-SHA256, so that
+// clients can't forge positions or sort orders the listing wasn't asked for
+type cursorCodec struct {
+	secret []byte
+}
+
+// newCursorCodec returns a cursorCodec signing with the given secret, or with a random
+// one if it is empty, in which case tokens don't survive a restart
+func newCursorCodec(secret []byte) cursorCodec {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(errors.Wrap(err, "failed to generate cursor secret"))
+		}
+	}
+	return cursorCodec{secret: secret}
+}
+
+// encode returns the token for a cursor, in the form payload.signature
+func (c cursorCodec) encode(cur cursor) string {
+	payload, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(c.sign(payload))
+}
+
+// decode verifies a token and returns the cursor it holds
+func (c cursorCodec) decode(token string) (cursor, error) {
+	var cur cursor
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return cur, errInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return cur, errInvalidCursor
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, c.sign(payload)) {
+		return cur, errInvalidCursor
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cur); err != nil || cur.Sort == "" || cur.ID == "" {
+		return cur, errInvalidCursor
+	}
+
+	return cur, nil
+}
+
+func (c cursorCodec) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// seekOptions returns the options to fetch the page a cursor points to
+func (cur cursor) seekOptions(limit int, filters []models.Filter) models.SeekOptions {
+	return models.SeekOptions{
+		Limit:    limit,
+		Sort:     models.SortField{Field: cur.Sort, Desc: cur.Desc},
+		Filters:  filters,
+		Keyset:   &models.Keyset{Value: cur.Value, ID: cur.ID},
+		Backward: cur.Backward,
+	}
+}
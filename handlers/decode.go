@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxBodySize bounds the size of request bodies when WithMaxBodySize isn't used
+const DefaultMaxBodySize = 1 << 20
+
+const jsonContentType = "application/json"
+
+// WithMaxBodySize sets the maximum size, in bytes, of request bodies. Larger bodies
+// are rejected with 413 Request Entity Too Large.
+func WithMaxBodySize(size int64) UsersHandlerOption {
+	return func(h *UsersHandler) {
+		h.maxBodySize = size
+	}
+}
+
+// newBodyTooLargeError returns a userError for bodies over the maximum size
+func newBodyTooLargeError(maxSize int64) *userError {
+	return &userError{
+		Status: http.StatusRequestEntityTooLarge,
+		Errors: []error{fmt.Errorf("body: must not exceed %d bytes", maxSize)},
+	}
+}
+
+// newUnsupportedMediaTypeError returns a userError for bodies of a content type other than those accepted
+func newUnsupportedMediaTypeError(mediaTypes ...string) *userError {
+	return &userError{
+		Status: http.StatusUnsupportedMediaType,
+		Errors: []error{fmt.Errorf("content type must be %s", strings.Join(mediaTypes, " or "))},
+	}
+}
+
+// readBody reads a request body of at most maxSize bytes
+func readBody(w http.ResponseWriter, r *http.Request, maxSize int64) ([]byte, *userError) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSize))
+	if err != nil {
+		return nil, bodyError(err, maxSize)
+	}
+	return body, nil
+}
+
+// decodeJSON strictly decodes a JSON request body of at most maxSize bytes into v. The body
+// must hold a single JSON value without fields unknown to v, and its content type must be
+// JSON, which is assumed when missing. Failures are returned as a userError describing them.
+func decodeJSON(w http.ResponseWriter, r *http.Request, maxSize int64, v interface{}) *userError {
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != jsonContentType {
+			return newUnsupportedMediaTypeError(jsonContentType)
+		}
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return bodyError(err, maxSize)
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		if _, ok := err.(*http.MaxBytesError); ok {
+			return newBodyTooLargeError(maxSize)
+		}
+		return newSimpleUserError(errors.New("body: must hold a single JSON value"))
+	}
+	return nil
+}
+
+// bodyError returns the userError describing an error reading or decoding a request body
+func bodyError(err error, maxSize int64) *userError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var sizeErr *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &sizeErr):
+		return newBodyTooLargeError(maxSize)
+	case errors.As(err, &syntaxErr):
+		return newSimpleUserError(fmt.Errorf("body: invalid JSON at byte offset %d", syntaxErr.Offset))
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			field = "body"
+		}
+		return newSimpleUserError(fmt.Errorf("%s: must be %s, at byte offset %d", field, jsonTypeName(typeErr.Type.Kind()), typeErr.Offset))
+	case err == io.EOF:
+		return newSimpleUserError(errors.New("body: must not be empty"))
+	case err == io.ErrUnexpectedEOF:
+		return newSimpleUserError(errors.New("body: truncated JSON"))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// the decoder has no error type for unknown fields
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return newSimpleUserError(fmt.Errorf("%s: unknown field", field))
+	default:
+		return newSimpleUserError(fmt.Errorf("body: %s", err))
+	}
+}
+
+// jsonTypeName names a Go kind as the JSON type it is decoded from
+func jsonTypeName(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	default:
+		return "a " + kind.String()
+	}
+}
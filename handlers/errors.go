@@ -1,12 +1,22 @@
 package handlers
 
 import (
-	"encoding/json"
 	"net/http"
+
+	"github.com/s1moe2/gosrv/problem"
+	"github.com/s1moe2/gosrv/repositories"
+	"github.com/s1moe2/gosrv/validation"
 )
 
+// statusClientClosedRequest is the non-standard status of the requests the client gave up
+// on before they were responded, as logged by nginx
+const statusClientClosedRequest = 499
+
+// customError is an error responded as problem details
 type customError interface {
+	error
 	StatusCode() int
+	problem() *problem.Problem
 }
 
 type ErrorList []error
@@ -23,28 +33,32 @@ func (el ErrorList) Error() string {
 	return ret
 }
 
-func (el ErrorList) MarshalJSON() ([]byte, error) {
-	data := []byte("[")
-	for i, err := range el {
-		if i != 0 {
-			data = append(data, ',')
-		}
-
-		errBytes, err := json.Marshal(err.Error())
-		if err != nil {
-			return nil, err
+// fieldErrors returns the errors of the list as problem details field errors, with the
+// code of the failed rule for validation errors
+func (el ErrorList) fieldErrors() []problem.FieldError {
+	fes := make([]problem.FieldError, 0, len(el))
+	for _, err := range el {
+		if fe, ok := err.(*validation.FieldError); ok {
+			fes = append(fes, problem.FieldError{Field: fe.Field, Code: fe.Code, Detail: fe.Message})
+			continue
 		}
-
-		data = append(data, errBytes...)
+		fes = append(fes, problem.ParseFieldError(err.Error()))
 	}
-	data = append(data, ']')
+	return fes
+}
 
-	return data, nil
+// validationErrors returns the errors of a validation as an ErrorList
+func validationErrors(errs validation.Errors) ErrorList {
+	var el ErrorList
+	for _, fe := range errs {
+		el = append(el, fe)
+	}
+	return el
 }
 
 type userError struct {
-	Status int       `json:"status"`
-	Errors ErrorList `json:"errors"`
+	Status int
+	Errors ErrorList
 }
 
 // newSimpleUserError returns a new userError with a default Bad Request status code
@@ -65,25 +79,57 @@ func newSimpleUserError(err error) *userError {
 	}
 }
 
+// newPreconditionError returns a new userError with a Precondition Failed status code,
+// for conditional requests whose entity tag no longer matches the resource
+func newPreconditionError() *userError {
+	return &userError{
+		Status: http.StatusPreconditionFailed,
+		Errors: []error{errPrecondition},
+	}
+}
+
+// newNotFoundError returns the error of a missing resource, responded as 404 along with
+// the not found errors of the repositories
+func newNotFoundError(message string) error {
+	return &repositories.NotFoundError{Message: message}
+}
+
+func (ue userError) Error() string {
+	return ue.Errors.Error()
+}
+
 func (ue userError) StatusCode() int {
 	return ue.Status
 }
 
+// problem returns the problem details of the error, listing every error found in the request
+func (ue userError) problem() *problem.Problem {
+	p := problem.New(ue.Status, ue.Errors.Error())
+	p.Errors = ue.Errors.fieldErrors()
+	return p
+}
+
 type internalError struct {
 	Status  int
 	Message string
-	Errors 	ErrorList
+}
+
+func (ie internalError) Error() string {
+	return ie.Message
 }
 
 func (ie internalError) StatusCode() int {
 	return ie.Status
 }
 
+func (ie internalError) problem() *problem.Problem {
+	return problem.New(ie.Status, ie.Message)
+}
+
 // internalError returns an internalError with 500 code and default message
 func newInternalError() internalError {
 	return internalError{
 		Status:  http.StatusInternalServerError,
 		Message: "Internal server error",
-		Errors: []error{},
 	}
 }
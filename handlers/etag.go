@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	errMultipleIfMatch = errors.New("If-Match: only a single entity tag is supported")
+	errPrecondition    = errors.New("resource has been modified, fetch it again before retrying")
+)
+
+// versionETag returns the strong entity tag of a resource version
+func versionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseETags splits the value of an If-Match or If-None-Match header into its entity tags
+func parseETags(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// noneMatch reports whether an If-None-Match header matches the given version,
+// using the weak comparison function as GET requests must
+func noneMatch(r *http.Request, version int64) bool {
+	etag := versionETag(version)
+	for _, tag := range parseETags(r.Header.Get("If-None-Match")) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// hasIfMatch reports whether a write is conditioned on an If-Match header, whatever its
+// tags: none of them, not even *, can match a user that doesn't exist
+func hasIfMatch(r *http.Request) bool {
+	return r.Header.Get("If-Match") != ""
+}
+
+// ifMatchVersion returns the version a write is conditioned on by its If-Match header,
+// or zero if the write is unconditional. Weak tags never match a strong comparison, so
+// they are reported as a version that can't exist.
+func ifMatchVersion(r *http.Request) (int64, error) {
+	tags := parseETags(r.Header.Get("If-Match"))
+	if len(tags) == 0 || (len(tags) == 1 && tags[0] == "*") {
+		return 0, nil
+	}
+	if len(tags) > 1 {
+		return 0, errMultipleIfMatch
+	}
+
+	unquoted := strings.TrimSuffix(strings.TrimPrefix(tags[0], `"`), `"`)
+	version, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil || version < 1 || strings.HasPrefix(tags[0], "W/") {
+		return -1, nil
+	}
+	return version, nil
+}
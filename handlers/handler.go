@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/s1moe2/gosrv/repositories"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// HandlerFunc is a request handler that returns its errors instead of responding them,
+// leaving it to ServeHTTP to map them to a response consistently across handlers
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// ServeHTTP calls the handler, responding the error it returns, if any, as problem details
+func (fn HandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := fn(w, r); err != nil {
+		respondErr(w, r, err)
+	}
+}
+
+// respondErr responds an error returned by a handler with the status matching it.
+// Errors the client can't act on are logged, and responded without their details.
+func respondErr(w http.ResponseWriter, r *http.Request, err error) {
+	e := errorResponse(err)
+	if e.StatusCode() >= http.StatusInternalServerError {
+		slog.ErrorContext(r.Context(), "request failed",
+			"error", err,
+			"method", r.Method,
+			"uri", r.RequestURI,
+		)
+		span := trace.SpanFromContext(r.Context())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	respondError(w, r, e)
+}
+
+// errorResponse returns the customError an error is responded as: handler errors as they
+// are, repository errors after their type, a request cancelled by the client as such, and
+// any other error as an internal error
+func errorResponse(err error) customError {
+	var ce customError
+	if errors.As(err, &ce) {
+		return ce
+	}
+
+	var notFound *repositories.NotFoundError
+	var conflict *repositories.ConflictError
+	var foreignKey *repositories.ForeignKeyError
+	var checkViolation *repositories.CheckViolationError
+	var stale *repositories.StaleVersionError
+	var timeout *repositories.TimeoutError
+	var unavailable *repositories.UnavailableError
+
+	switch {
+	case errors.As(err, &notFound):
+		return &userError{Status: http.StatusNotFound, Errors: []error{notFound}}
+	case errors.As(err, &conflict):
+		return &userError{Status: http.StatusConflict, Errors: []error{conflict}}
+	case errors.As(err, &foreignKey):
+		return &userError{Status: http.StatusConflict, Errors: []error{foreignKey}}
+	case errors.As(err, &checkViolation):
+		return newSimpleUserError(checkViolation)
+	case errors.As(err, &stale):
+		return newPreconditionError()
+	case errors.As(err, &timeout):
+		return internalError{Status: http.StatusGatewayTimeout, Message: "the database took too long to respond"}
+	case errors.As(err, &unavailable):
+		return internalError{Status: http.StatusServiceUnavailable, Message: "the database is unavailable"}
+	case errors.Is(err, context.Canceled):
+		return internalError{Status: statusClientClosedRequest, Message: "the client closed the request"}
+	default:
+		return newInternalError()
+	}
+}
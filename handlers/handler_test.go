@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/s1moe2/gosrv/repositories"
+)
+
+func TestHandlerFunc_ServeHTTP(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"no error", nil, http.StatusTeapot},
+		{"user error", newSimpleUserError(errors.New("name: invalid")), http.StatusBadRequest},
+		{"not found", &repositories.NotFoundError{Message: "user not found"}, http.StatusNotFound},
+		{"conflict", &repositories.ConflictError{Message: "[email] already exists"}, http.StatusConflict},
+		{"foreign key", &repositories.ForeignKeyError{Message: "[team_id] references a missing record"}, http.StatusConflict},
+		{"check violation", &repositories.CheckViolationError{Message: "[name] can't be null"}, http.StatusBadRequest},
+		{"stale version", &repositories.StaleVersionError{Message: "user has been modified"}, http.StatusPreconditionFailed},
+		{"timeout", &repositories.TimeoutError{Message: "statement timed out"}, http.StatusGatewayTimeout},
+		{"unavailable", &repositories.UnavailableError{Message: "database unavailable"}, http.StatusServiceUnavailable},
+		{"cancelled", errors.Wrap(context.Canceled, "failed to begin transaction"), statusClientClosedRequest},
+		{"wrapped", errors.Wrap(&repositories.ConflictError{Message: "[email] already exists"}, "failed to commit transaction"), http.StatusConflict},
+		{"other", errors.New("boom"), http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			handler := HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+				if c.err != nil {
+					return c.err
+				}
+				w.WriteHeader(http.StatusTeapot)
+				return nil
+			})
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users", nil))
+			resp := w.Result()
+
+			assertStatusCode(t, resp, c.status)
+			if c.err != nil {
+				assertProblemContentType(t, resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+// patchTestError is returned when a JSON Patch test operation doesn't match the document
+type patchTestError struct {
+	path string
+}
+
+func (e *patchTestError) Error() string {
+	return fmt.Sprintf("%s: test failed", e.path)
+}
+
+// patchOp is a single JSON Patch (RFC 6902) operation
+type patchOp struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// applyMergePatch applies a JSON Merge Patch (RFC 7396) to a decoded JSON document
+func applyMergePatch(target interface{}, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = map[string]interface{}{}
+	}
+
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = applyMergePatch(targetObj[key], value)
+	}
+
+	return targetObj
+}
+
+// applyJSONPatch applies a JSON Patch (RFC 6902) to a decoded JSON document.
+// Only the test, replace and remove operations are supported. Operations are
+// applied in order, and the document is left untouched if any of them fails.
+func applyJSONPatch(doc interface{}, ops []patchOp) (interface{}, error) {
+	doc = deepCopyJSON(doc)
+
+	for i, op := range ops {
+		tokens, err := parsePointer(op.Path)
+		if err != nil {
+			return nil, fmt.Errorf("patch[%d]: %s", i, err)
+		}
+
+		switch op.Op {
+		case "test":
+			value, err := op.value(i)
+			if err != nil {
+				return nil, err
+			}
+			current, ok := lookupPointer(doc, tokens)
+			if !ok || !reflect.DeepEqual(current, value) {
+				return nil, &patchTestError{path: op.Path}
+			}
+		case "replace":
+			value, err := op.value(i)
+			if err != nil {
+				return nil, err
+			}
+			doc, err = replacePointer(doc, tokens, value)
+			if err != nil {
+				return nil, fmt.Errorf("patch[%d]: %s", i, err)
+			}
+		case "remove":
+			doc, err = removePointer(doc, tokens)
+			if err != nil {
+				return nil, fmt.Errorf("patch[%d]: %s", i, err)
+			}
+		default:
+			return nil, fmt.Errorf("patch[%d]: unsupported op %q", i, op.Op)
+		}
+	}
+
+	return doc, nil
+}
+
+// value decodes the value member of an operation that requires one
+func (op patchOp) value(i int) (interface{}, error) {
+	if op.Value == nil {
+		return nil, fmt.Errorf("patch[%d]: missing value", i)
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(*op.Value, &value); err != nil {
+		return nil, fmt.Errorf("patch[%d]: invalid value", i)
+	}
+	return value, nil
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.New("path must be empty or start with '/'")
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+// lookupPointer returns the value a pointer refers to, and whether it exists
+func lookupPointer(doc interface{}, tokens []string) (interface{}, bool) {
+	for _, token := range tokens {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, false
+			}
+			doc = value
+		case []interface{}:
+			idx, err := arrayIndex(token, len(node))
+			if err != nil {
+				return nil, false
+			}
+			doc = node[idx]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// replacePointer replaces the existing value a pointer refers to
+func replacePointer(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	parent, ok := lookupPointer(doc, tokens[:len(tokens)-1])
+	if !ok {
+		return nil, errors.New("path not found")
+	}
+
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		if _, ok := node[last]; !ok {
+			return nil, errors.New("path not found")
+		}
+		node[last] = value
+	case []interface{}:
+		idx, err := arrayIndex(last, len(node))
+		if err != nil {
+			return nil, err
+		}
+		node[idx] = value
+	default:
+		return nil, errors.New("path not found")
+	}
+
+	return doc, nil
+}
+
+// removePointer removes the existing value a pointer refers to
+func removePointer(doc interface{}, tokens []string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("can't remove the whole document")
+	}
+
+	parentTokens := tokens[:len(tokens)-1]
+	parent, ok := lookupPointer(doc, parentTokens)
+	if !ok {
+		return nil, errors.New("path not found")
+	}
+
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		if _, ok := node[last]; !ok {
+			return nil, errors.New("path not found")
+		}
+		delete(node, last)
+	case []interface{}:
+		idx, err := arrayIndex(last, len(node))
+		if err != nil {
+			return nil, err
+		}
+		// arrays shrink, so the parent holding the slice must be updated too
+		return replacePointer(doc, parentTokens, append(node[:idx:idx], node[idx+1:]...))
+	default:
+		return nil, errors.New("path not found")
+	}
+
+	return doc, nil
+}
+
+// arrayIndex parses an array reference token, which must point to an existing element
+func arrayIndex(token string, length int) (int, error) {
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 || idx >= length || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	return idx, nil
+}
+
+// deepCopyJSON copies a decoded JSON document so that it can be patched in place
+func deepCopyJSON(doc interface{}) interface{} {
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		cp := make(map[string]interface{}, len(node))
+		for k, v := range node {
+			cp[k] = deepCopyJSON(v)
+		}
+		return cp
+	case []interface{}:
+		cp := make([]interface{}, len(node))
+		for i, v := range node {
+			cp[i] = deepCopyJSON(v)
+		}
+		return cp
+	default:
+		return doc
+	}
+}
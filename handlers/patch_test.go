@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func mustUnmarshalJSON(t *testing.T, doc string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		t.Fatalf("invalid test document: %v", err)
+	}
+	return v
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	// examples from RFC 7396, appendix A
+	cases := []struct {
+		target, patch, expected string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+	}
+
+	for _, c := range cases {
+		got := applyMergePatch(mustUnmarshalJSON(t, c.target), mustUnmarshalJSON(t, c.patch))
+		if !reflect.DeepEqual(got, mustUnmarshalJSON(t, c.expected)) {
+			t.Errorf("merging %s into %s: expected %s, got %v", c.patch, c.target, c.expected, got)
+		}
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	t.Run("expect ops to be applied in order", func(t *testing.T) {
+		doc := mustUnmarshalJSON(t, `{"a/b":1,"m~n":[1,2,3],"c":{"d":"e"}}`)
+		var ops []patchOp
+		_ = json.Unmarshal([]byte(`[
+			{"op":"test","path":"/a~1b","value":1},
+			{"op":"replace","path":"/c/d","value":"f"},
+			{"op":"remove","path":"/m~0n/1"}
+		]`), &ops)
+
+		got, err := applyJSONPatch(doc, ops)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, mustUnmarshalJSON(t, `{"a/b":1,"m~n":[1,3],"c":{"d":"f"}}`)) {
+			t.Fatalf("unexpected result %v", got)
+		}
+	})
+
+	t.Run("expect the document to be untouched when an op fails", func(t *testing.T) {
+		doc := mustUnmarshalJSON(t, `{"a":1}`)
+		var ops []patchOp
+		_ = json.Unmarshal([]byte(`[
+			{"op":"replace","path":"/a","value":2},
+			{"op":"remove","path":"/b"}
+		]`), &ops)
+
+		if _, err := applyJSONPatch(doc, ops); err == nil {
+			t.Fatal("expected an error removing a missing path")
+		}
+		if !reflect.DeepEqual(doc, mustUnmarshalJSON(t, `{"a":1}`)) {
+			t.Fatalf("expected the document to be untouched, got %v", doc)
+		}
+	})
+
+	t.Run("expect unsupported ops to be rejected", func(t *testing.T) {
+		var ops []patchOp
+		_ = json.Unmarshal([]byte(`[{"op":"add","path":"/a","value":1}]`), &ops)
+
+		if _, err := applyJSONPatch(mustUnmarshalJSON(t, `{}`), ops); err == nil {
+			t.Fatal("expected an error on an unsupported op")
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/s1moe2/gosrv/models"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// listQuery describes which fields of a resource a listing can be sorted and filtered by
+type listQuery struct {
+	sortable   map[string]bool
+	filterable map[string]bool
+}
+
+// parse reads limit, offset, sort and filter parameters from a query string.
+// Filters are given as field=value for equality or field~=value for a
+// case insensitive substring match, sort as a comma separated list of fields
+// where a leading '-' means descending order.
+func (lq listQuery) parse(query url.Values) (models.ListOptions, []error) {
+	opts := models.ListOptions{Limit: defaultPageLimit}
+	var errs []error
+
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := query.Get(key)
+
+		switch key {
+		case "limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > maxPageLimit {
+				errs = append(errs, fmt.Errorf("limit: must be an integer between 1 and %d", maxPageLimit))
+				continue
+			}
+			opts.Limit = limit
+		case "offset":
+			offset, err := strconv.Atoi(value)
+			if err != nil || offset < 0 {
+				errs = append(errs, errors.New("offset: must be a positive integer"))
+				continue
+			}
+			opts.Offset = offset
+		case "include_deleted":
+			includeDeleted, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, errors.New("include_deleted: must be a boolean"))
+				continue
+			}
+			opts.IncludeDeleted = includeDeleted
+		case "cursor":
+			// keyset pagination, handled by UsersHandler.getByCursor
+		case "sort":
+			sortFields, sortErrs := lq.parseSort(value)
+			opts.Sort = sortFields
+			errs = append(errs, sortErrs...)
+		default:
+			field, op := key, models.FilterEq
+			if strings.HasSuffix(key, "~") {
+				field, op = strings.TrimSuffix(key, "~"), models.FilterContains
+			}
+
+			if !lq.filterable[field] {
+				errs = append(errs, fmt.Errorf("%s: unknown query parameter", key))
+				continue
+			}
+			opts.Filters = append(opts.Filters, models.Filter{Field: field, Op: op, Value: value})
+		}
+	}
+
+	return opts, errs
+}
+
+func (lq listQuery) parseSort(value string) ([]models.SortField, []error) {
+	var fields []models.SortField
+	var errs []error
+
+	for _, term := range strings.Split(value, ",") {
+		field := strings.TrimPrefix(term, "-")
+		if !lq.sortable[field] {
+			errs = append(errs, fmt.Errorf("sort: unknown field %q", field))
+			continue
+		}
+		fields = append(fields, models.SortField{Field: field, Desc: strings.HasPrefix(term, "-")})
+	}
+
+	return fields, errs
+}
+
+// setPaginationHeaders sets the X-Total-Count header and a Link header with the
+// first, prev, next and last pages of an offset paginated listing
+func setPaginationHeaders(w http.ResponseWriter, r *http.Request, opts models.ListOptions, total int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+
+	pageURL := func(offset int) string {
+		u := *r.URL
+		q := u.Query()
+		q.Set("limit", strconv.Itoa(opts.Limit))
+		q.Set("offset", strconv.Itoa(offset))
+		u.RawQuery = q.Encode()
+		return u.RequestURI()
+	}
+
+	lastOffset := 0
+	if total > 0 {
+		lastOffset = (total - 1) / opts.Limit * opts.Limit
+	}
+
+	links := []string{fmt.Sprintf(`<%s>; rel="first"`, pageURL(0))}
+	if opts.Offset > 0 {
+		prev := opts.Offset - opts.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(prev)))
+	}
+	if opts.Offset+opts.Limit < total {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(opts.Offset+opts.Limit)))
+	}
+	links = append(links, fmt.Sprintf(`<%s>; rel="last"`, pageURL(lastOffset)))
+
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+// setCursorHeaders sets the X-Next-Cursor and X-Prev-Cursor headers and a Link
+// header with the next and prev pages of a keyset paginated listing
+func setCursorHeaders(w http.ResponseWriter, r *http.Request, next string, prev string) {
+	pageURL := func(token string) string {
+		u := *r.URL
+		q := u.Query()
+		q.Set("cursor", token)
+		u.RawQuery = q.Encode()
+		return u.RequestURI()
+	}
+
+	var links []string
+	if prev != "" {
+		w.Header().Set("X-Prev-Cursor", prev)
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(prev)))
+	}
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(next)))
+	}
+
+	if links != nil {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
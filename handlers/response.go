@@ -3,6 +3,9 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // respond is an helper that takes care of the
@@ -17,11 +20,10 @@ func respond(w http.ResponseWriter, data interface{}, code int) {
 
 }
 
-// respondError is an helper similar to respond but only used for custom errors
-func respondError(w http.ResponseWriter, e customError) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(e.StatusCode())
-	err := json.NewEncoder(w).Encode(e)
+// respondError is an helper similar to respond but only used for custom errors,
+// which are written as problem details tagged with the ID of the request that failed
+func respondError(w http.ResponseWriter, r *http.Request, e customError) {
+	err := e.problem().Write(w, r)
 	if err != nil {
 		// TODO add log on error
 	}
@@ -29,11 +31,17 @@ func respondError(w http.ResponseWriter, e customError) {
 
 // respondInternalError is an helper similar to respondError but responds
 // with a default internal error code and payload
-func respondInternalError(w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(http.StatusInternalServerError)
-	err := json.NewEncoder(w).Encode(newInternalError())
+func respondInternalError(w http.ResponseWriter, r *http.Request) {
+	ie := newInternalError()
+	trace.SpanFromContext(r.Context()).SetStatus(codes.Error, ie.Message)
+	err := ie.problem().Write(w, r)
 	if err != nil {
 		// TODO add log on error
 	}
-}
\ No newline at end of file
+}
+
+// RespondInternalError responds with the default internal error code and payload, for
+// code outside the handlers that must fail a request, such as the recovery from panics
+func RespondInternalError(w http.ResponseWriter, r *http.Request) {
+	respondInternalError(w, r)
+}
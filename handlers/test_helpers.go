@@ -2,15 +2,16 @@ package handlers
 
 import (
 	"github.com/gorilla/mux"
+	"github.com/s1moe2/gosrv/problem"
 	"net/http"
 	"testing"
 )
 
-func prepareRouter(method string, path string, h func(http.ResponseWriter, *http.Request)) *mux.Router {
+func prepareRouter(method string, path string, h HandlerFunc) *mux.Router {
 	router := mux.NewRouter()
 	router.Methods(method).
 		Path(path).
-		HandlerFunc(h)
+		Handler(h)
 	return router
 }
 
@@ -20,6 +21,12 @@ func assertContentType(t *testing.T, r *http.Response) {
 	}
 }
 
+func assertProblemContentType(t *testing.T, r *http.Response) {
+	if r.Header.Get("Content-Type") != problem.ContentType {
+		t.Fatalf("expected '%s', got '%s'", problem.ContentType, r.Header.Get("Content-Type"))
+	}
+}
+
 func assertStatusCode(t *testing.T, r *http.Response, status int) {
 	if r.StatusCode != status {
 		t.Fatalf("expected %d response, got %d", status, r.StatusCode)
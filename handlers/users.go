@@ -1,198 +1,591 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
-	"github.com/s1moe2/gosrv/repositories"
+	"mime"
 	"net/http"
-	"regexp"
+	"net/url"
+	"sort"
+	"strconv"
 
 	"github.com/s1moe2/gosrv/models"
+	"github.com/s1moe2/gosrv/validation"
 )
 
 // UsersHandler holds handler dependencies
 type UsersHandler struct {
-	userRepo models.UserRepository
+	userRepo     models.UserRepository
+	tx           models.TxRunner
+	cursorSecret []byte
+	cursors      cursorCodec
+	maxBodySize  int64
+}
+
+// UsersHandlerOption configures optional UsersHandler behaviour
+type UsersHandlerOption func(h *UsersHandler)
+
+// WithCursorSecret sets the secret used to sign pagination cursors.
+// Without it, a random secret is used and cursors don't survive a restart.
+func WithCursorSecret(secret []byte) UsersHandlerOption {
+	return func(h *UsersHandler) {
+		h.cursorSecret = secret
+	}
 }
 
 type UserPayload struct {
-	Name  string
-	Email string
+	Name  string `json:"name" validate:"required,min=3,max=100"`
+	Email string `json:"email" validate:"required,email,max=254"`
 }
 
 func (p *UserPayload) validate() []error {
+	return validationErrors(validation.Struct(p))
+}
+
+// patchedUserPayload builds the payload of a patched user document, validating
+// only the fields the patch touched and keeping the others from the stored user
+func patchedUserPayload(user *models.User, patched interface{}, touched []string) (*UserPayload, []error) {
+	doc, ok := patched.(map[string]interface{})
+	if !ok {
+		return nil, []error{errors.New("patch: must result in a JSON object")}
+	}
+
+	payload := &UserPayload{Name: user.Name, Email: user.Email}
+	fields := map[string]*string{"name": &payload.Name, "email": &payload.Email}
+
 	var errs []error
+	for _, field := range touched {
+		if field == "id" {
+			if doc["id"] != user.ID {
+				errs = append(errs, errors.New("id: can't be changed"))
+			}
+			continue
+		}
 
-	if len(p.Name) < 3 {
-		errs = append(errs, errors.New("name: invalid length"))
+		dst, ok := fields[field]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: unknown field", field))
+			continue
+		}
+
+		value, ok := doc[field]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: can't be removed", field))
+			continue
+		}
+		str, ok := value.(string)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: must be a string", field))
+			continue
+		}
+		*dst = str
+	}
+
+	// the stored fields were valid when written, and the rules may have changed since
+	isTouched := map[string]bool{}
+	for _, field := range touched {
+		isTouched[field] = true
+	}
+	for _, fe := range validation.Struct(payload) {
+		if isTouched[fe.Field] {
+			errs = append(errs, fe)
+		}
 	}
 
-	const emailRegex = "^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$"
-	emailRegexp := regexp.MustCompile(emailRegex)
-	if !emailRegexp.MatchString(p.Email) {
-		errs = append(errs, errors.New("email: invalid format"))
+	return payload, errs
+}
+
+// usersListQuery holds the user fields GET /users can be sorted and filtered by
+var usersListQuery = listQuery{
+	sortable:   map[string]bool{"id": true, "name": true, "email": true},
+	filterable: map[string]bool{"name": true, "email": true},
+}
+
+// WithTxRunner sets the TxRunner used by handlers that read and write users atomically.
+// Without it, repository calls run outside of any transaction.
+func WithTxRunner(tx models.TxRunner) UsersHandlerOption {
+	return func(h *UsersHandler) {
+		h.tx = tx
 	}
+}
 
-	return errs
+// noTx is a models.TxRunner that runs functions without a transaction
+type noTx struct{}
+
+func (noTx) RunInTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
 }
 
 // NewBaseHandler returns a new BaseHandler
-func NewUsersHandler(userRepo models.UserRepository) *UsersHandler {
-	return &UsersHandler{
-		userRepo: userRepo,
+func NewUsersHandler(userRepo models.UserRepository, opts ...UsersHandlerOption) *UsersHandler {
+	h := &UsersHandler{
+		userRepo:    userRepo,
+		tx:          noTx{},
+		maxBodySize: DefaultMaxBodySize,
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
+	h.cursors = newCursorCodec(h.cursorSecret)
+	return h
 }
 
-// Get gets all users
-func (h *UsersHandler) Get(w http.ResponseWriter, r *http.Request) {
-	users, err := h.userRepo.GetAll(r.Context())
+// Get gets a page of users, optionally sorted and filtered.
+// Pages are fetched by offset, or by keyset when a cursor parameter is present.
+func (h *UsersHandler) Get(w http.ResponseWriter, r *http.Request) error {
+	r, span := startSpan(r, "UsersHandler.Get")
+	defer span.End()
+
+	query := r.URL.Query()
+	if _, ok := query["cursor"]; ok {
+		return h.getByCursor(w, r, query)
+	}
+
+	opts, errs := usersListQuery.parse(query)
+	if errs != nil {
+		return newUserError(errs)
+	}
+
+	users, total, err := h.userRepo.List(r.Context(), opts)
 	if err != nil {
-		respondInternalError(w)
-		return
+		return err
 	}
 
+	setPaginationHeaders(w, r, opts, total)
 	respond(w, users, http.StatusOK)
+	return nil
 }
 
-// GetByID tries to get a user by ID
-func (h *UsersHandler) GetByID(w http.ResponseWriter, r *http.Request) {
+// getByCursor gets the page of users a cursor points to, or the first page if the cursor is empty
+func (h *UsersHandler) getByCursor(w http.ResponseWriter, r *http.Request, query url.Values) error {
+	opts, errs := usersListQuery.parse(query)
+	if opts.Offset > 0 {
+		errs = append(errs, errors.New("offset: can't be combined with cursor"))
+	}
+	if len(opts.Sort) > 1 {
+		errs = append(errs, errors.New("sort: only one field can be combined with cursor"))
+	}
+
+	// fetch one extra user to know whether there is another page
+	seek := models.SeekOptions{
+		Limit:          opts.Limit + 1,
+		Sort:           models.SortField{Field: "id"},
+		Filters:        opts.Filters,
+		IncludeDeleted: opts.IncludeDeleted,
+	}
+	if len(opts.Sort) == 1 {
+		seek.Sort = opts.Sort[0]
+	}
+
+	if token := query.Get("cursor"); token != "" {
+		cur, err := h.cursors.decode(token)
+		if err != nil {
+			errs = append(errs, err)
+		} else if len(opts.Sort) == 1 && (opts.Sort[0].Field != cur.Sort || opts.Sort[0].Desc != cur.Desc) {
+			errs = append(errs, errors.New("sort: doesn't match the cursor"))
+		} else {
+			seek = cur.seekOptions(opts.Limit+1, opts.Filters)
+			seek.IncludeDeleted = opts.IncludeDeleted
+		}
+	}
+
+	if errs != nil {
+		return newUserError(errs)
+	}
+
+	users, err := h.userRepo.Seek(r.Context(), seek)
+	if err != nil {
+		return err
+	}
+
+	hasMore := len(users) > opts.Limit
+	if hasMore {
+		if seek.Backward {
+			users = users[1:]
+		} else {
+			users = users[:opts.Limit]
+		}
+	}
+
+	var next, prev string
+	if len(users) > 0 {
+		if hasMore || seek.Backward {
+			next = h.cursors.encode(userCursor(users[len(users)-1], seek.Sort, false))
+		}
+		if (seek.Keyset != nil && !seek.Backward) || (seek.Backward && hasMore) {
+			prev = h.cursors.encode(userCursor(users[0], seek.Sort, true))
+		}
+	}
+
+	setCursorHeaders(w, r, next, prev)
+	respond(w, users, http.StatusOK)
+	return nil
+}
+
+// userCursor returns a cursor positioned at a user for a given sort order
+func userCursor(user *models.User, order models.SortField, backward bool) cursor {
+	cur := cursor{
+		Sort:     order.Field,
+		Desc:     order.Desc,
+		ID:       user.ID,
+		Backward: backward,
+	}
+
+	switch order.Field {
+	case "name":
+		cur.Value = user.Name
+	case "email":
+		cur.Value = user.Email
+	}
+
+	return cur
+}
+
+// GetByID tries to get a user by ID, including deleted users if asked to
+func (h *UsersHandler) GetByID(w http.ResponseWriter, r *http.Request) error {
+	r, span := startSpan(r, "UsersHandler.GetByID")
+	defer span.End()
+
 	vars := mux.Vars(r)
 	uid, ok := vars["id"]
 	if !ok {
-		respondError(w, newSimpleUserError(errors.New("invalid id param")))
-		return
+		return newSimpleUserError(errors.New("invalid id param"))
 	}
 
-	user, err := h.userRepo.FindByID(r.Context(), uid)
+	includeDeleted := false
+	if value := r.URL.Query().Get("include_deleted"); value != "" {
+		var err error
+		includeDeleted, err = strconv.ParseBool(value)
+		if err != nil {
+			return newSimpleUserError(errors.New("include_deleted: must be a boolean"))
+		}
+	}
+
+	user, err := h.userRepo.FindByID(r.Context(), uid, includeDeleted)
 	if err != nil {
-		respondInternalError(w)
-		return
+		return err
 	}
 
 	if user == nil {
-		respondError(w, &userError{
-			Status: http.StatusNotFound,
-			Errors: []error{errors.New("user not found")},
-		})
-		return
+		return newNotFoundError("user not found")
+	}
+
+	w.Header().Set("ETag", versionETag(user.Version))
+	if noneMatch(r, user.Version) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
 	}
 
 	respond(w, user, http.StatusOK)
+	return nil
 }
 
-// Create creates a new user
-func (h *UsersHandler) Create(w http.ResponseWriter, r *http.Request) {
-	decoder := json.NewDecoder(r.Body)
+// Create creates a new user. An email already in use is only caught by the repository,
+// as checking it beforehand would race with other requests creating users.
+func (h *UsersHandler) Create(w http.ResponseWriter, r *http.Request) error {
+	r, span := startSpan(r, "UsersHandler.Create")
+	defer span.End()
 
 	var userPayload UserPayload
-	err := decoder.Decode(&userPayload)
-	if err != nil {
-		respondInternalError(w)
-		return
+	if e := decodeJSON(w, r, h.maxBodySize, &userPayload); e != nil {
+		return e
 	}
 
 	errs := userPayload.validate()
 	if errs != nil {
-		respondError(w, newUserError(errs))
-		return
-	}
-
-	userCheck, err := h.userRepo.FindByEmail(r.Context(), userPayload.Email)
-	if err != nil {
-		respondInternalError(w)
-		return
-	}
-
-	if userCheck != nil {
-		respondError(w, newSimpleUserError(errors.New("email already in use")))
-		return
+		return newUserError(errs)
 	}
 
-	user, err := h.userRepo.Create(&models.User{
+	user, err := h.userRepo.Create(r.Context(), &models.User{
 		Name:  userPayload.Name,
 		Email: userPayload.Email,
 	})
 	if err != nil {
-		respondInternalError(w)
-		return
+		return err
 	}
 
+	w.Header().Set("ETag", versionETag(user.Version))
 	respond(w, user, http.StatusCreated)
+	return nil
 }
 
 // Update updates a user
-func (h *UsersHandler) Update(w http.ResponseWriter, r *http.Request) {
+func (h *UsersHandler) Update(w http.ResponseWriter, r *http.Request) error {
+	r, span := startSpan(r, "UsersHandler.Update")
+	defer span.End()
+
 	vars := mux.Vars(r)
 	uid, ok := vars["id"]
 	if !ok {
-		respondError(w, newSimpleUserError(errors.New("invalid id param")))
-		return
+		return newSimpleUserError(errors.New("invalid id param"))
 	}
 
-	decoder := json.NewDecoder(r.Body)
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		return newSimpleUserError(err)
+	}
 
 	var userPayload UserPayload
-	err := decoder.Decode(&userPayload)
-	if err != nil {
-		respondInternalError(w)
-		return
+	if e := decodeJSON(w, r, h.maxBodySize, &userPayload); e != nil {
+		return e
 	}
 
 	errs := userPayload.validate()
 	if errs != nil {
-		respondError(w, newUserError(errs))
-		return
+		return newUserError(errs)
 	}
 
-	user, err := h.userRepo.Update(&models.User{
-		ID:    uid,
-		Name:  userPayload.Name,
-		Email: userPayload.Email,
+	user, err := h.userRepo.Update(r.Context(), &models.User{
+		ID:      uid,
+		Name:    userPayload.Name,
+		Email:   userPayload.Email,
+		Version: version,
 	})
 	if err != nil {
-		if e, ok := err.(*repositories.ConflictError); ok {
-			respondError(w, newSimpleUserError(e))
-			return
+		return err
+	}
+
+	if user == nil {
+		return missingUserError(r)
+	}
+
+	w.Header().Set("ETag", versionETag(user.Version))
+	respond(w, user, http.StatusOK)
+	return nil
+}
+
+// Patch partially updates a user with either a JSON Merge Patch (RFC 7396)
+// or a JSON Patch (RFC 6902) document, depending on the request content type.
+// Only the fields the patch touches are validated.
+func (h *UsersHandler) Patch(w http.ResponseWriter, r *http.Request) error {
+	r, span := startSpan(r, "UsersHandler.Patch")
+	defer span.End()
+
+	vars := mux.Vars(r)
+	uid, ok := vars["id"]
+	if !ok {
+		return newSimpleUserError(errors.New("invalid id param"))
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != mergePatchContentType && mediaType != jsonPatchContentType {
+		return newUnsupportedMediaTypeError(mergePatchContentType, jsonPatchContentType)
+	}
+
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		return newSimpleUserError(err)
+	}
+
+	body, e := readBody(w, r, h.maxBodySize)
+	if e != nil {
+		return e
+	}
+
+	// the user is read and written in the same transaction, and the update only
+	// goes through if it is still the version the patch was applied to
+	var user *models.User
+	var userErr *userError
+	err = h.tx.RunInTx(r.Context(), func(ctx context.Context) error {
+		stored, err := h.userRepo.FindByID(ctx, uid, false)
+		if err != nil || stored == nil {
+			return err
 		}
 
-		respondInternalError(w)
-		return
+		if version != 0 && version != stored.Version {
+			userErr = newPreconditionError()
+			return nil
+		}
+
+		userPayload, e := patchUserDocument(stored, mediaType, body)
+		if e != nil {
+			userErr = e
+			return nil
+		}
+
+		user, err = h.userRepo.Update(ctx, &models.User{
+			ID:      uid,
+			Name:    userPayload.Name,
+			Email:   userPayload.Email,
+			Version: stored.Version,
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if userErr != nil {
+		return userErr
 	}
 
 	if user == nil {
-		respondError(w, &userError{
-			Status: http.StatusNotFound,
-			Errors: []error{errors.New("user not found")},
-		})
-		return
+		return missingUserError(r)
 	}
 
+	w.Header().Set("ETag", versionETag(user.Version))
 	respond(w, user, http.StatusOK)
+	return nil
+}
+
+// patchUserDocument applies a patch document of the given media type to a user,
+// returning the resulting payload
+func patchUserDocument(user *models.User, mediaType string, body []byte) (*UserPayload, *userError) {
+	doc := map[string]interface{}{"id": user.ID, "name": user.Name, "email": user.Email}
+	var patched interface{}
+	var touched []string
+
+	switch mediaType {
+	case mergePatchContentType:
+		var patch interface{}
+		if err := json.Unmarshal(body, &patch); err != nil {
+			return nil, newSimpleUserError(errors.New("patch: invalid JSON document"))
+		}
+
+		patched = applyMergePatch(doc, patch)
+		if obj, ok := patch.(map[string]interface{}); ok {
+			for field := range obj {
+				touched = append(touched, field)
+			}
+		}
+	case jsonPatchContentType:
+		var ops []patchOp
+		if err := json.Unmarshal(body, &ops); err != nil {
+			return nil, newSimpleUserError(errors.New("patch: invalid JSON document"))
+		}
+
+		var err error
+		patched, err = applyJSONPatch(doc, ops)
+		if err != nil {
+			if _, ok := err.(*patchTestError); ok {
+				return nil, &userError{
+					Status: http.StatusConflict,
+					Errors: []error{err},
+				}
+			}
+			return nil, newSimpleUserError(err)
+		}
+
+		seen := map[string]bool{}
+		touch := func(field string) {
+			if !seen[field] {
+				seen[field] = true
+				touched = append(touched, field)
+			}
+		}
+		for _, op := range ops {
+			if op.Op == "test" {
+				continue
+			}
+			if tokens, _ := parsePointer(op.Path); len(tokens) > 0 {
+				touch(tokens[0])
+				continue
+			}
+			// an op on the root replaces the whole document, touching all of its fields
+			touch("name")
+			touch("email")
+			if obj, ok := patched.(map[string]interface{}); ok {
+				for field := range obj {
+					touch(field)
+				}
+			}
+		}
+	}
+
+	sort.Strings(touched)
+	userPayload, errs := patchedUserPayload(user, patched, touched)
+	if errs != nil {
+		return nil, newUserError(errs)
+	}
+	return userPayload, nil
 }
 
-// Delete deletes a user
-func (h *UsersHandler) Delete(w http.ResponseWriter, r *http.Request) {
+// Delete soft deletes a user
+func (h *UsersHandler) Delete(w http.ResponseWriter, r *http.Request) error {
+	r, span := startSpan(r, "UsersHandler.Delete")
+	defer span.End()
+
 	vars := mux.Vars(r)
 	uid, ok := vars["id"]
 	if !ok {
-		respondError(w, newSimpleUserError(errors.New("invalid id param")))
-		return
+		return newSimpleUserError(errors.New("invalid id param"))
 	}
 
-	deleted, err := h.userRepo.Delete(uid)
+	version, err := ifMatchVersion(r)
 	if err != nil {
-		respondInternalError(w)
-		return
+		return newSimpleUserError(err)
+	}
+
+	deleted, err := h.userRepo.Delete(r.Context(), uid, version)
+	if err != nil {
+		return err
 	}
 
 	if !deleted {
-		respondError(w, &userError{
-			Status: http.StatusNotFound,
-			Errors: []error{errors.New("user not found")},
-		})
-		return
+		return missingUserError(r)
+	}
+
+	respond(w, nil, http.StatusNoContent)
+	return nil
+}
+
+// missingUserError returns the error of a write to a user that doesn't exist: 412 when
+// it is conditioned on If-Match, which a missing user can't match (RFC 9110), else 404
+func missingUserError(r *http.Request) error {
+	if hasIfMatch(r) {
+		return newPreconditionError()
+	}
+	return newNotFoundError("user not found")
+}
+
+// Restore restores a deleted user
+func (h *UsersHandler) Restore(w http.ResponseWriter, r *http.Request) error {
+	r, span := startSpan(r, "UsersHandler.Restore")
+	defer span.End()
+
+	vars := mux.Vars(r)
+	uid, ok := vars["id"]
+	if !ok {
+		return newSimpleUserError(errors.New("invalid id param"))
+	}
+
+	user, err := h.userRepo.Restore(r.Context(), uid)
+	if err != nil {
+		return err
+	}
+
+	if user == nil {
+		return newNotFoundError("deleted user not found")
+	}
+
+	w.Header().Set("ETag", versionETag(user.Version))
+	respond(w, user, http.StatusOK)
+	return nil
+}
+
+// Purge permanently deletes a user, whether it was deleted before or not
+func (h *UsersHandler) Purge(w http.ResponseWriter, r *http.Request) error {
+	r, span := startSpan(r, "UsersHandler.Purge")
+	defer span.End()
+
+	vars := mux.Vars(r)
+	uid, ok := vars["id"]
+	if !ok {
+		return newSimpleUserError(errors.New("invalid id param"))
+	}
+
+	purged, err := h.userRepo.Purge(r.Context(), uid)
+	if err != nil {
+		return err
+	}
+
+	if !purged {
+		return newNotFoundError("user not found")
 	}
 
 	respond(w, nil, http.StatusNoContent)
+	return nil
 }
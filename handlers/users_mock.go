@@ -7,11 +7,15 @@ import (
 
 type userRepoMock struct {
 	getAllImpl      func() ([]*models.User, error)
-	findByIDImpl    func(ID string) (*models.User, error)
+	listImpl        func(opts models.ListOptions) ([]*models.User, int, error)
+	seekImpl        func(opts models.SeekOptions) ([]*models.User, error)
+	findByIDImpl    func(ID string, includeDeleted bool) (*models.User, error)
 	findByEmailImpl func(email string) (*models.User, error)
 	createImpl      func(user *models.User) (*models.User, error)
 	updateImpl      func(user *models.User) (*models.User, error)
-	deleteImpl      func(ID string) (bool, error)
+	deleteImpl      func(ID string, version int64) (bool, error)
+	restoreImpl     func(ID string) (*models.User, error)
+	purgeImpl       func(ID string) (bool, error)
 }
 
 func newUserRepoMockDefault() *userRepoMock {
@@ -22,22 +26,69 @@ func (r *userRepoMock) GetAll(_ context.Context) ([]*models.User, error) {
 	return r.getAllImpl()
 }
 
-func (r *userRepoMock) FindByID(_ context.Context, id string) (*models.User, error) {
-	return r.findByIDImpl(id)
+func (r *userRepoMock) List(_ context.Context, opts models.ListOptions) ([]*models.User, int, error) {
+	return r.listImpl(opts)
+}
+
+func (r *userRepoMock) Seek(_ context.Context, opts models.SeekOptions) ([]*models.User, error) {
+	return r.seekImpl(opts)
+}
+
+func (r *userRepoMock) FindByID(_ context.Context, id string, includeDeleted bool) (*models.User, error) {
+	return r.findByIDImpl(id, includeDeleted)
 }
 
 func (r *userRepoMock) FindByEmail(_ context.Context, email string) (*models.User, error) {
 	return r.findByEmailImpl(email)
 }
 
-func (r *userRepoMock) Create(user *models.User) (*models.User, error) {
+// the write methods behave like database/sql and don't run once their context is done,
+// so that tests can check that handlers abort writes of cancelled requests
+
+func (r *userRepoMock) Create(ctx context.Context, user *models.User) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return r.createImpl(user)
 }
 
-func (r *userRepoMock) Update(user *models.User) (*models.User, error) {
+func (r *userRepoMock) Update(ctx context.Context, user *models.User) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return r.updateImpl(user)
 }
 
-func (r *userRepoMock) Delete(id string) (bool, error) {
-	return r.deleteImpl(id)
+func (r *userRepoMock) Delete(ctx context.Context, id string, version int64) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return r.deleteImpl(id, version)
+}
+
+func (r *userRepoMock) Restore(ctx context.Context, id string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.restoreImpl(id)
+}
+
+func (r *userRepoMock) Purge(ctx context.Context, id string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return r.purgeImpl(id)
+}
+
+// txRunnerMock runs functions without a transaction, recording what they returned
+type txRunnerMock struct {
+	runs int
+	errs []error
+}
+
+func (m *txRunnerMock) RunInTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	m.runs++
+	err := fn(ctx)
+	m.errs = append(m.errs, err)
+	return err
 }
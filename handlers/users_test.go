@@ -2,19 +2,26 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/s1moe2/gosrv/models"
+	"github.com/s1moe2/gosrv/problem"
+	"github.com/s1moe2/gosrv/reqctx"
+	"github.com/s1moe2/gosrv/repositories"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 )
 
 func TestUsersHandler_Get(t *testing.T) {
 	t.Run("expect GET /users to return 200 and a list of users", func(t *testing.T) {
 		mock := newUserRepoMockDefault()
-		mock.getAllImpl = func() ([]*models.User, error) {
+		mock.listImpl = func(opts models.ListOptions) ([]*models.User, int, error) {
 			mockUsers := []*models.User{
 				&models.User{
 					ID:    "1",
@@ -22,7 +29,7 @@ func TestUsersHandler_Get(t *testing.T) {
 					Email: "user1@eml.com",
 				},
 			}
-			return mockUsers, nil
+			return mockUsers, 1, nil
 		}
 		uh := NewUsersHandler(mock)
 
@@ -50,8 +57,8 @@ func TestUsersHandler_Get(t *testing.T) {
 
 	t.Run("expect GET /users to return 200 and an empty list of users", func(t *testing.T) {
 		mock := newUserRepoMockDefault()
-		mock.getAllImpl = func() ([]*models.User, error) {
-			return []*models.User{}, nil
+		mock.listImpl = func(opts models.ListOptions) ([]*models.User, int, error) {
+			return []*models.User{}, 0, nil
 		}
 		uh := NewUsersHandler(mock)
 
@@ -79,8 +86,8 @@ func TestUsersHandler_Get(t *testing.T) {
 
 	t.Run("expect GET /users to return 500 on internal error", func(t *testing.T) {
 		mock := newUserRepoMockDefault()
-		mock.getAllImpl = func() ([]*models.User, error) {
-			return nil, errors.New("repo error")
+		mock.listImpl = func(opts models.ListOptions) ([]*models.User, int, error) {
+			return nil, 0, errors.New("repo error")
 		}
 		uh := NewUsersHandler(mock)
 
@@ -93,12 +100,202 @@ func TestUsersHandler_Get(t *testing.T) {
 
 		assertStatusCode(t, resp, http.StatusInternalServerError)
 	})
+
+	t.Run("expect GET /users to pass pagination, sort and filters to the repository", func(t *testing.T) {
+		var got models.ListOptions
+		mock := newUserRepoMockDefault()
+		mock.listImpl = func(opts models.ListOptions) ([]*models.User, int, error) {
+			got = opts
+			return []*models.User{}, 25, nil
+		}
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("GET", "/users?limit=10&offset=10&sort=name,-email&name~=doe&email=john@gosrv.com", nil)
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodGet, "/users", uh.Get)
+		router.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusOK)
+
+		expected := models.ListOptions{
+			Limit:  10,
+			Offset: 10,
+			Sort: []models.SortField{
+				{Field: "name"},
+				{Field: "email", Desc: true},
+			},
+			Filters: []models.Filter{
+				{Field: "email", Op: models.FilterEq, Value: "john@gosrv.com"},
+				{Field: "name", Op: models.FilterContains, Value: "doe"},
+			},
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("expected options %+v, got %+v", expected, got)
+		}
+
+		if resp.Header.Get("X-Total-Count") != "25" {
+			t.Fatalf("expected X-Total-Count 25, got '%s'", resp.Header.Get("X-Total-Count"))
+		}
+
+		link := resp.Header.Get("Link")
+		for _, rel := range []string{`rel="first"`, `rel="prev"`, `rel="next"`, `rel="last"`} {
+			if !strings.Contains(link, rel) {
+				t.Fatalf("expected Link header to contain %s, got '%s'", rel, link)
+			}
+		}
+	})
+
+	t.Run("expect GET /users to return 400 on invalid query parameters", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("GET", "/users?limit=0&sort=password&role=admin", nil)
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodGet, "/users", uh.Get)
+		router.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusBadRequest)
+	})
+}
+
+func TestUsersHandler_GetByCursor(t *testing.T) {
+	users := []*models.User{
+		{ID: "1", Name: "ann", Email: "ann@eml.com"},
+		{ID: "2", Name: "bob", Email: "bob@eml.com"},
+		{ID: "3", Name: "cid", Email: "cid@eml.com"},
+	}
+
+	t.Run("expect GET /users?cursor= to return the first page and a next cursor", func(t *testing.T) {
+		var got models.SeekOptions
+		mock := newUserRepoMockDefault()
+		mock.seekImpl = func(opts models.SeekOptions) ([]*models.User, error) {
+			got = opts
+			return users, nil
+		}
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("GET", "/users?cursor=&limit=2&sort=-name", nil)
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodGet, "/users", uh.Get)
+		router.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusOK)
+		assertContentType(t, resp)
+
+		if got.Limit != 3 || got.Keyset != nil || got.Sort != (models.SortField{Field: "name", Desc: true}) {
+			t.Fatalf("unexpected seek options %+v", got)
+		}
+
+		var page []*models.User
+		body, _ := ioutil.ReadAll(resp.Body)
+		if err := json.Unmarshal(body, &page); err != nil || len(page) != 2 {
+			t.Fatalf("expected a page of 2 users, got '%s'", body)
+		}
+
+		next, err := uh.cursors.decode(resp.Header.Get("X-Next-Cursor"))
+		if err != nil {
+			t.Fatalf("expected a valid next cursor, got %v", err)
+		}
+		if next != (cursor{Sort: "name", Desc: true, Value: "bob", ID: "2"}) {
+			t.Fatalf("unexpected next cursor %+v", next)
+		}
+		if resp.Header.Get("X-Prev-Cursor") != "" {
+			t.Fatal("expected no prev cursor on the first page")
+		}
+	})
+
+	t.Run("expect GET /users?cursor={token} to seek from the cursor", func(t *testing.T) {
+		var got models.SeekOptions
+		mock := newUserRepoMockDefault()
+		mock.seekImpl = func(opts models.SeekOptions) ([]*models.User, error) {
+			got = opts
+			return users[2:], nil
+		}
+		uh := NewUsersHandler(mock)
+		token := uh.cursors.encode(cursor{Sort: "name", Value: "bob", ID: "2"})
+
+		r := httptest.NewRequest("GET", "/users?limit=2&cursor="+token, nil)
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodGet, "/users", uh.Get)
+		router.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusOK)
+
+		if got.Keyset == nil || *got.Keyset != (models.Keyset{Value: "bob", ID: "2"}) || got.Backward {
+			t.Fatalf("unexpected seek options %+v", got)
+		}
+		if resp.Header.Get("X-Next-Cursor") != "" {
+			t.Fatal("expected no next cursor on the last page")
+		}
+		if _, err := uh.cursors.decode(resp.Header.Get("X-Prev-Cursor")); err != nil {
+			t.Fatalf("expected a valid prev cursor, got %v", err)
+		}
+	})
+
+	t.Run("expect GET /users?cursor={token} to return 400 on a tampered cursor", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		uh := NewUsersHandler(mock, WithCursorSecret([]byte("secret")))
+		forged := NewUsersHandler(mock, WithCursorSecret([]byte("other")))
+		token := forged.cursors.encode(cursor{Sort: "id", ID: "2"})
+
+		r := httptest.NewRequest("GET", "/users?cursor="+token, nil)
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodGet, "/users", uh.Get)
+		router.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusBadRequest)
+	})
+
+	t.Run("expect GET /users?cursor={token} to return 400 when the sort doesn't match the cursor", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		mock.seekImpl = func(opts models.SeekOptions) ([]*models.User, error) {
+			t.Fatal("expected no seek")
+			return nil, nil
+		}
+		uh := NewUsersHandler(mock)
+		token := uh.cursors.encode(cursor{Sort: "name", Value: "bob", ID: "2"})
+
+		for _, sort := range []string{"email", "-name"} {
+			r := httptest.NewRequest("GET", "/users?sort="+sort+"&cursor="+token, nil)
+			w := httptest.NewRecorder()
+			router := prepareRouter(http.MethodGet, "/users", uh.Get)
+			router.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			assertStatusCode(t, resp, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("expect GET /users?cursor= to return 400 when combined with offset", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("GET", "/users?cursor=&offset=10", nil)
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodGet, "/users", uh.Get)
+		router.ServeHTTP(w, r)
+
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusBadRequest)
+	})
 }
 
 func TestUsersHandler_GetByID(t *testing.T) {
 	t.Run("expect GET /users/{id} to return 200", func(t *testing.T) {
 		mock := newUserRepoMockDefault()
-		mock.findByIDImpl = func(ID string) (*models.User, error) {
+		mock.findByIDImpl = func(ID string, includeDeleted bool) (*models.User, error) {
 			return &models.User{
 				ID:    "1",
 				Name:  "user1",
@@ -131,7 +328,7 @@ func TestUsersHandler_GetByID(t *testing.T) {
 
 	t.Run("expect GET /users/{id} to return 404 when user does not exist", func(t *testing.T) {
 		mock := newUserRepoMockDefault()
-		mock.findByIDImpl = func(ID string) (*models.User, error) {
+		mock.findByIDImpl = func(ID string, includeDeleted bool) (*models.User, error) {
 			return nil, nil
 		}
 		uh := NewUsersHandler(mock)
@@ -148,7 +345,7 @@ func TestUsersHandler_GetByID(t *testing.T) {
 
 	t.Run("expect GET /users/{id} to return 500 on internal error", func(t *testing.T) {
 		mock := newUserRepoMockDefault()
-		mock.findByIDImpl = func(ID string) (*models.User, error) {
+		mock.findByIDImpl = func(ID string, includeDeleted bool) (*models.User, error) {
 			return nil, errors.New("repo error")
 		}
 		uh := NewUsersHandler(mock)
@@ -172,9 +369,6 @@ func TestUsersHandler_Create(t *testing.T) {
 
 	t.Run("expect POST /users to return 201", func(t *testing.T) {
 		mock := newUserRepoMockDefault()
-		mock.findByEmailImpl = func(email string) (*models.User, error) {
-			return nil, nil
-		}
 		mock.createImpl = func(user *models.User) (*models.User, error) {
 			user.ID = "3"
 			return user, nil
@@ -204,14 +398,35 @@ func TestUsersHandler_Create(t *testing.T) {
 		}
 	})
 
-	t.Run("expect POST /users to return 400 when the email is in use", func(t *testing.T) {
+	t.Run("expect POST /users to return problem details listing the invalid fields", func(t *testing.T) {
+		uh := NewUsersHandler(newUserRepoMockDefault())
+
+		r := httptest.NewRequest("POST", "/users", strings.NewReader(`{"name":"Jo","email":"nope"}`))
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPost, "/users", uh.Create)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusBadRequest)
+		assertProblemContentType(t, resp)
+
+		var body problem.Problem
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal("failed to parse response body")
+		}
+		expected := []problem.FieldError{
+			{Field: "name", Code: "min", Detail: "must be at least 3 characters long"},
+			{Field: "email", Code: "email", Detail: "must be an email address"},
+		}
+		if body.Title != "Bad Request" || body.Instance != "/users" || !reflect.DeepEqual(body.Errors, expected) {
+			t.Fatalf("unexpected problem %+v", body)
+		}
+	})
+
+	t.Run("expect POST /users to return 409 when the email is in use", func(t *testing.T) {
 		mock := newUserRepoMockDefault()
-		mock.findByEmailImpl = func(email string) (*models.User, error) {
-			return &models.User{
-				ID:    "5",
-				Name:  "John Doe",
-				Email: email,
-			}, nil
+		mock.createImpl = func(user *models.User) (*models.User, error) {
+			return nil, &repositories.ConflictError{Message: "[email] already exists with this value (johndoe@gosrv.com)"}
 		}
 		uh := NewUsersHandler(mock)
 
@@ -222,13 +437,14 @@ func TestUsersHandler_Create(t *testing.T) {
 		router.ServeHTTP(w, r)
 		resp := w.Result()
 
-		assertStatusCode(t, resp, http.StatusBadRequest)
+		assertStatusCode(t, resp, http.StatusConflict)
+		assertProblemContentType(t, resp)
 	})
 
-	t.Run("expect POST /users to return 500 on find internal error", func(t *testing.T) {
+	t.Run("expect POST /users to return 503 when the database is unavailable", func(t *testing.T) {
 		mock := newUserRepoMockDefault()
-		mock.findByEmailImpl = func(email string) (*models.User, error) {
-			return nil, errors.New("repo error")
+		mock.createImpl = func(user *models.User) (*models.User, error) {
+			return nil, &repositories.UnavailableError{Message: "database unavailable"}
 		}
 		uh := NewUsersHandler(mock)
 
@@ -239,14 +455,11 @@ func TestUsersHandler_Create(t *testing.T) {
 		router.ServeHTTP(w, r)
 		resp := w.Result()
 
-		assertStatusCode(t, resp, http.StatusInternalServerError)
+		assertStatusCode(t, resp, http.StatusServiceUnavailable)
 	})
 
 	t.Run("expect POST /users to return 500 on create internal error", func(t *testing.T) {
 		mock := newUserRepoMockDefault()
-		mock.findByEmailImpl = func(email string) (*models.User, error) {
-			return nil, nil
-		}
 		mock.createImpl = func(user *models.User) (*models.User, error) {
 			return nil, errors.New("repo error")
 		}
@@ -332,12 +545,29 @@ func TestUsersHandler_Update(t *testing.T) {
 
 		assertStatusCode(t, resp, http.StatusInternalServerError)
 	})
+
+	t.Run("expect PUT /users/{id} to return 409 when the email is in use", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		mock.updateImpl = func(user *models.User) (*models.User, error) {
+			return nil, &repositories.ConflictError{Message: "[email] already exists with this value (johndoe@gosrv.com)"}
+		}
+		uh := NewUsersHandler(mock)
+
+		body, _ := json.Marshal(mockPayload)
+		r := httptest.NewRequest("PUT", "/users/1", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPut, "/users/{id}", uh.Update)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusConflict)
+	})
 }
 
 func TestUsersHandler_Delete(t *testing.T) {
 	t.Run("expect DELETE /users/{id} to return 204", func(t *testing.T) {
 		mock := newUserRepoMockDefault()
-		mock.deleteImpl = func(ID string) (bool, error) {
+		mock.deleteImpl = func(ID string, version int64) (bool, error) {
 			return true, nil
 		}
 		uh := NewUsersHandler(mock)
@@ -355,7 +585,7 @@ func TestUsersHandler_Delete(t *testing.T) {
 
 	t.Run("expect DELETE /users/{id} to return 404 when user does not exist", func(t *testing.T) {
 		mock := newUserRepoMockDefault()
-		mock.deleteImpl = func(ID string) (bool, error) {
+		mock.deleteImpl = func(ID string, version int64) (bool, error) {
 			return false, nil
 		}
 		uh := NewUsersHandler(mock)
@@ -372,7 +602,7 @@ func TestUsersHandler_Delete(t *testing.T) {
 
 	t.Run("expect DELETE /users/{id} to return 500 on internal error", func(t *testing.T) {
 		mock := newUserRepoMockDefault()
-		mock.deleteImpl = func(ID string) (bool, error) {
+		mock.deleteImpl = func(ID string, version int64) (bool, error) {
 			return false, errors.New("repo error")
 		}
 		uh := NewUsersHandler(mock)
@@ -387,3 +617,728 @@ func TestUsersHandler_Delete(t *testing.T) {
 		assertStatusCode(t, resp, http.StatusInternalServerError)
 	})
 }
+
+func TestUsersHandler_Patch(t *testing.T) {
+	stored := func(ID string, includeDeleted bool) (*models.User, error) {
+		return &models.User{
+			ID:    ID,
+			Name:  "John Doe",
+			Email: "johndoe@gosrv.com",
+		}, nil
+	}
+
+	t.Run("expect PATCH /users/{id} with a merge patch to update only the given fields", func(t *testing.T) {
+		var updated *models.User
+		mock := newUserRepoMockDefault()
+		mock.findByIDImpl = stored
+		mock.updateImpl = func(user *models.User) (*models.User, error) {
+			updated = user
+			return user, nil
+		}
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("PATCH", "/users/1", strings.NewReader(`{"name": "Jane Doe"}`))
+		r.Header.Set("Content-Type", "application/merge-patch+json")
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPatch, "/users/{id}", uh.Patch)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusOK)
+		assertContentType(t, resp)
+
+		expected := &models.User{ID: "1", Name: "Jane Doe", Email: "johndoe@gosrv.com"}
+		if !reflect.DeepEqual(updated, expected) {
+			t.Fatalf("expected update with %+v, got %+v", expected, updated)
+		}
+	})
+
+	t.Run("expect PATCH /users/{id} with a merge patch to return 400 when removing a required field", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		mock.findByIDImpl = stored
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("PATCH", "/users/1", strings.NewReader(`{"email": null}`))
+		r.Header.Set("Content-Type", "application/merge-patch+json")
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPatch, "/users/{id}", uh.Patch)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusBadRequest)
+	})
+
+	t.Run("expect PATCH /users/{id} with a JSON patch to apply test and replace ops", func(t *testing.T) {
+		var updated *models.User
+		mock := newUserRepoMockDefault()
+		mock.findByIDImpl = stored
+		mock.updateImpl = func(user *models.User) (*models.User, error) {
+			updated = user
+			return user, nil
+		}
+		uh := NewUsersHandler(mock)
+
+		patch := `[
+			{"op": "test", "path": "/email", "value": "johndoe@gosrv.com"},
+			{"op": "replace", "path": "/email", "value": "jane@gosrv.com"}
+		]`
+		r := httptest.NewRequest("PATCH", "/users/1", strings.NewReader(patch))
+		r.Header.Set("Content-Type", "application/json-patch+json")
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPatch, "/users/{id}", uh.Patch)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusOK)
+
+		expected := &models.User{ID: "1", Name: "John Doe", Email: "jane@gosrv.com"}
+		if !reflect.DeepEqual(updated, expected) {
+			t.Fatalf("expected update with %+v, got %+v", expected, updated)
+		}
+	})
+
+	t.Run("expect PATCH /users/{id} with a JSON patch to return 409 and not update when a test op fails", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		mock.findByIDImpl = stored
+		mock.updateImpl = func(user *models.User) (*models.User, error) {
+			t.Fatal("expected no update")
+			return nil, nil
+		}
+		uh := NewUsersHandler(mock)
+
+		patch := `[
+			{"op": "replace", "path": "/name", "value": "Jane Doe"},
+			{"op": "test", "path": "/email", "value": "other@gosrv.com"}
+		]`
+		r := httptest.NewRequest("PATCH", "/users/1", strings.NewReader(patch))
+		r.Header.Set("Content-Type", "application/json-patch+json")
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPatch, "/users/{id}", uh.Patch)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusConflict)
+	})
+
+	t.Run("expect PATCH /users/{id} with a JSON patch on the root to replace and validate the whole document", func(t *testing.T) {
+		cases := []struct {
+			patch    string
+			status   int
+			expected *models.User
+		}{
+			{`[{"op": "replace", "path": "", "value": {"name": "Jane Doe", "email": "jane@gosrv.com"}}]`,
+				http.StatusOK, &models.User{ID: "1", Name: "Jane Doe", Email: "jane@gosrv.com"}},
+			{`[{"op": "replace", "path": "", "value": {"id": "1", "name": "Jane Doe", "email": "jane@gosrv.com"}}]`,
+				http.StatusOK, &models.User{ID: "1", Name: "Jane Doe", Email: "jane@gosrv.com"}},
+			{`[{"op": "replace", "path": "", "value": {"name": "Changed", "email": "not-an-email"}}]`, http.StatusBadRequest, nil},
+			{`[{"op": "replace", "path": "", "value": {"name": "Jane Doe"}}]`, http.StatusBadRequest, nil},
+			{`[{"op": "add", "path": "", "value": {"name": "Jane Doe", "email": "jane@gosrv.com"}}]`, http.StatusBadRequest, nil},
+			{`[{"op": "remove", "path": ""}]`, http.StatusBadRequest, nil},
+			{`[{"op": "replace", "path": "", "value": {"id": "2", "name": "Jane Doe", "email": "jane@gosrv.com"}}]`, http.StatusBadRequest, nil},
+		}
+		for _, c := range cases {
+			var updated *models.User
+			mock := newUserRepoMockDefault()
+			mock.findByIDImpl = stored
+			mock.updateImpl = func(user *models.User) (*models.User, error) {
+				updated = user
+				return user, nil
+			}
+			uh := NewUsersHandler(mock)
+
+			r := httptest.NewRequest("PATCH", "/users/1", strings.NewReader(c.patch))
+			r.Header.Set("Content-Type", "application/json-patch+json")
+			w := httptest.NewRecorder()
+			router := prepareRouter(http.MethodPatch, "/users/{id}", uh.Patch)
+			router.ServeHTTP(w, r)
+			resp := w.Result()
+
+			assertStatusCode(t, resp, c.status)
+			if !reflect.DeepEqual(updated, c.expected) {
+				t.Fatalf("expected update with %+v for %s, got %+v", c.expected, c.patch, updated)
+			}
+		}
+	})
+
+	t.Run("expect PATCH /users/{id} to return 400 on an invalid patched field", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		mock.findByIDImpl = stored
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("PATCH", "/users/1", strings.NewReader(`{"email": "not-an-email"}`))
+		r.Header.Set("Content-Type", "application/merge-patch+json")
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPatch, "/users/{id}", uh.Patch)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusBadRequest)
+	})
+
+	t.Run("expect PATCH /users/{id} to return 415 on an unsupported content type", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("PATCH", "/users/1", strings.NewReader(`{"name": "Jane Doe"}`))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPatch, "/users/{id}", uh.Patch)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusUnsupportedMediaType)
+	})
+
+	t.Run("expect PATCH /users/{id} to return 404 when the user does not exist", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		mock.findByIDImpl = func(ID string, includeDeleted bool) (*models.User, error) {
+			return nil, nil
+		}
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("PATCH", "/users/1", strings.NewReader(`{"name": "Jane Doe"}`))
+		r.Header.Set("Content-Type", "application/merge-patch+json")
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPatch, "/users/{id}", uh.Patch)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusNotFound)
+	})
+}
+
+func TestUsersHandler_ConditionalRequests(t *testing.T) {
+	stored := func(ID string, includeDeleted bool) (*models.User, error) {
+		return &models.User{
+			ID:      ID,
+			Name:    "John Doe",
+			Email:   "johndoe@gosrv.com",
+			Version: 3,
+		}, nil
+	}
+
+	t.Run("expect GET /users/{id} to return the version as ETag", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		mock.findByIDImpl = stored
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("GET", "/users/1", nil)
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodGet, "/users/{id}", uh.GetByID)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusOK)
+		if resp.Header.Get("ETag") != `"3"` {
+			t.Fatalf(`expected ETag "3", got '%s'`, resp.Header.Get("ETag"))
+		}
+	})
+
+	t.Run("expect GET /users/{id} to return 304 when If-None-Match matches", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		mock.findByIDImpl = stored
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("GET", "/users/1", nil)
+		r.Header.Set("If-None-Match", `"2", W/"3"`)
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodGet, "/users/{id}", uh.GetByID)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusNotModified)
+		if w.Body.Len() != 0 {
+			t.Fatalf("expected an empty body, got '%s'", w.Body.String())
+		}
+	})
+
+	t.Run("expect PUT /users/{id} to pass the If-Match version to the repository", func(t *testing.T) {
+		var got int64
+		mock := newUserRepoMockDefault()
+		mock.updateImpl = func(user *models.User) (*models.User, error) {
+			got = user.Version
+			user.Version++
+			return user, nil
+		}
+		uh := NewUsersHandler(mock)
+
+		body := strings.NewReader(`{"name": "John Doe", "email": "johndoe@gosrv.com"}`)
+		r := httptest.NewRequest("PUT", "/users/1", body)
+		r.Header.Set("If-Match", `"3"`)
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPut, "/users/{id}", uh.Update)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusOK)
+		if got != 3 {
+			t.Fatalf("expected version 3, got %d", got)
+		}
+		if resp.Header.Get("ETag") != `"4"` {
+			t.Fatalf(`expected ETag "4", got '%s'`, resp.Header.Get("ETag"))
+		}
+	})
+
+	t.Run("expect PUT /users/{id} to return 412 when the version is stale", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		mock.updateImpl = func(user *models.User) (*models.User, error) {
+			return nil, &repositories.StaleVersionError{Message: "stale"}
+		}
+		uh := NewUsersHandler(mock)
+
+		body := strings.NewReader(`{"name": "John Doe", "email": "johndoe@gosrv.com"}`)
+		r := httptest.NewRequest("PUT", "/users/1", body)
+		r.Header.Set("If-Match", `"2"`)
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPut, "/users/{id}", uh.Update)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusPreconditionFailed)
+	})
+
+	t.Run("expect PATCH /users/{id} to return 412 when If-Match doesn't match the stored version", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		mock.findByIDImpl = stored
+		mock.updateImpl = func(user *models.User) (*models.User, error) {
+			t.Fatal("expected no update")
+			return nil, nil
+		}
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("PATCH", "/users/1", strings.NewReader(`{"name": "Jane Doe"}`))
+		r.Header.Set("Content-Type", "application/merge-patch+json")
+		r.Header.Set("If-Match", `"2"`)
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPatch, "/users/{id}", uh.Patch)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusPreconditionFailed)
+	})
+
+	t.Run("expect PATCH /users/{id} to update conditionally on the patched version", func(t *testing.T) {
+		var got int64
+		mock := newUserRepoMockDefault()
+		mock.findByIDImpl = stored
+		mock.updateImpl = func(user *models.User) (*models.User, error) {
+			got = user.Version
+			return nil, &repositories.StaleVersionError{Message: "stale"}
+		}
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("PATCH", "/users/1", strings.NewReader(`{"name": "Jane Doe"}`))
+		r.Header.Set("Content-Type", "application/merge-patch+json")
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPatch, "/users/{id}", uh.Patch)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusPreconditionFailed)
+		if got != 3 {
+			t.Fatalf("expected version 3, got %d", got)
+		}
+	})
+
+	t.Run("expect DELETE /users/{id} to return 412 when the version is stale", func(t *testing.T) {
+		var got int64
+		mock := newUserRepoMockDefault()
+		mock.deleteImpl = func(ID string, version int64) (bool, error) {
+			got = version
+			return false, &repositories.StaleVersionError{Message: "stale"}
+		}
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("DELETE", "/users/1", nil)
+		r.Header.Set("If-Match", `"2"`)
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodDelete, "/users/{id}", uh.Delete)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusPreconditionFailed)
+		if got != 2 {
+			t.Fatalf("expected version 2, got %d", got)
+		}
+	})
+
+	t.Run("expect conditional writes to a missing user to return 412", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		mock.findByIDImpl = func(ID string, includeDeleted bool) (*models.User, error) {
+			return nil, nil
+		}
+		mock.updateImpl = func(user *models.User) (*models.User, error) {
+			return nil, nil
+		}
+		mock.deleteImpl = func(ID string, version int64) (bool, error) {
+			return false, nil
+		}
+		uh := NewUsersHandler(mock)
+
+		cases := []struct {
+			method      string
+			contentType string
+			body        string
+			handler     func(w http.ResponseWriter, r *http.Request) error
+		}{
+			{http.MethodPut, "application/json", `{"name": "John Doe", "email": "johndoe@gosrv.com"}`, uh.Update},
+			{http.MethodPatch, "application/merge-patch+json", `{"name": "Jane Doe"}`, uh.Patch},
+			{http.MethodDelete, "", "", uh.Delete},
+		}
+		for _, c := range cases {
+			for _, ifMatch := range []string{`"3"`, "*", ""} {
+				r := httptest.NewRequest(c.method, "/users/1", strings.NewReader(c.body))
+				if c.contentType != "" {
+					r.Header.Set("Content-Type", c.contentType)
+				}
+				if ifMatch != "" {
+					r.Header.Set("If-Match", ifMatch)
+				}
+				w := httptest.NewRecorder()
+				router := prepareRouter(c.method, "/users/{id}", c.handler)
+				router.ServeHTTP(w, r)
+				resp := w.Result()
+
+				if ifMatch == "" {
+					assertStatusCode(t, resp, http.StatusNotFound)
+				} else {
+					assertStatusCode(t, resp, http.StatusPreconditionFailed)
+				}
+			}
+		}
+	})
+}
+
+func TestUsersHandler_SoftDelete(t *testing.T) {
+	t.Run("expect GET /users/{id}?include_deleted=true to look up deleted users", func(t *testing.T) {
+		var got bool
+		mock := newUserRepoMockDefault()
+		mock.findByIDImpl = func(ID string, includeDeleted bool) (*models.User, error) {
+			got = includeDeleted
+			return &models.User{ID: ID, Name: "user1", Email: "user1@eml.com"}, nil
+		}
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("GET", "/users/1?include_deleted=true", nil)
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodGet, "/users/{id}", uh.GetByID)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusOK)
+		if !got {
+			t.Fatal("expected deleted users to be included")
+		}
+	})
+
+	t.Run("expect GET /users?include_deleted=true to list deleted users", func(t *testing.T) {
+		var got models.ListOptions
+		mock := newUserRepoMockDefault()
+		mock.listImpl = func(opts models.ListOptions) ([]*models.User, int, error) {
+			got = opts
+			return []*models.User{}, 0, nil
+		}
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("GET", "/users?include_deleted=true", nil)
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodGet, "/users", uh.Get)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusOK)
+		if !got.IncludeDeleted {
+			t.Fatal("expected deleted users to be included")
+		}
+	})
+
+	t.Run("expect POST /users/{id}/restore to return 200", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		mock.restoreImpl = func(ID string) (*models.User, error) {
+			return &models.User{ID: ID, Name: "user1", Email: "user1@eml.com", Version: 3}, nil
+		}
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("POST", "/users/1/restore", nil)
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPost, "/users/{id}/restore", uh.Restore)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusOK)
+		assertContentType(t, resp)
+	})
+
+	t.Run("expect POST /users/{id}/restore to return 404 when there is no such deleted user", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		mock.restoreImpl = func(ID string) (*models.User, error) {
+			return nil, nil
+		}
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("POST", "/users/1/restore", nil)
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPost, "/users/{id}/restore", uh.Restore)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusNotFound)
+	})
+
+	t.Run("expect POST /users/{id}/restore to return 409 when the email has been reused", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		mock.restoreImpl = func(ID string) (*models.User, error) {
+			return nil, &repositories.ConflictError{Message: "[email] already exists with this value (user1@eml.com)"}
+		}
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("POST", "/users/1/restore", nil)
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPost, "/users/{id}/restore", uh.Restore)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusConflict)
+	})
+
+	t.Run("expect POST /users/{id}/purge to return 204", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		mock.purgeImpl = func(ID string) (bool, error) {
+			return true, nil
+		}
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("POST", "/users/1/purge", nil)
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPost, "/users/{id}/purge", uh.Purge)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusNoContent)
+	})
+
+	t.Run("expect POST /users/{id}/purge to return 404 when the user does not exist", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		mock.purgeImpl = func(ID string) (bool, error) {
+			return false, nil
+		}
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest("POST", "/users/1/purge", nil)
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPost, "/users/{id}/purge", uh.Purge)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusNotFound)
+	})
+}
+
+func TestUsersHandler_CancelledRequests(t *testing.T) {
+	cancelled := func(r *http.Request) *http.Request {
+		ctx, cancel := context.WithCancel(r.Context())
+		cancel()
+		return r.WithContext(ctx)
+	}
+
+	t.Run("expect POST /users to not create the user when the request is cancelled", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		mock.createImpl = func(user *models.User) (*models.User, error) {
+			t.Fatal("expected the write to be aborted")
+			return nil, nil
+		}
+		uh := NewUsersHandler(mock)
+
+		body := strings.NewReader(`{"name": "John Doe", "email": "johndoe@gosrv.com"}`)
+		r := cancelled(httptest.NewRequest("POST", "/users", body))
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPost, "/users", uh.Create)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, statusClientClosedRequest)
+	})
+
+	t.Run("expect PUT /users/{id} to not update the user when the request is cancelled", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		mock.updateImpl = func(user *models.User) (*models.User, error) {
+			t.Fatal("expected the write to be aborted")
+			return nil, nil
+		}
+		uh := NewUsersHandler(mock)
+
+		body := strings.NewReader(`{"name": "John Doe", "email": "johndoe@gosrv.com"}`)
+		r := cancelled(httptest.NewRequest("PUT", "/users/1", body))
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPut, "/users/{id}", uh.Update)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, statusClientClosedRequest)
+	})
+
+	t.Run("expect DELETE /users/{id} to not delete the user when the request is cancelled", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		mock.deleteImpl = func(ID string, version int64) (bool, error) {
+			t.Fatal("expected the write to be aborted")
+			return false, nil
+		}
+		uh := NewUsersHandler(mock)
+
+		r := cancelled(httptest.NewRequest("DELETE", "/users/1", nil))
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodDelete, "/users/{id}", uh.Delete)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, statusClientClosedRequest)
+	})
+}
+
+func TestUsersHandler_Transactions(t *testing.T) {
+	stored := func(ID string, includeDeleted bool) (*models.User, error) {
+		return &models.User{ID: ID, Name: "John Doe", Email: "johndoe@gosrv.com", Version: 1}, nil
+	}
+
+	t.Run("expect PATCH /users/{id} to read and write the user in a single transaction", func(t *testing.T) {
+		tx := &txRunnerMock{}
+		mock := newUserRepoMockDefault()
+		mock.findByIDImpl = stored
+		mock.updateImpl = func(user *models.User) (*models.User, error) {
+			return user, nil
+		}
+		uh := NewUsersHandler(mock, WithTxRunner(tx))
+
+		r := httptest.NewRequest("PATCH", "/users/1", strings.NewReader(`{"name": "Jane Doe"}`))
+		r.Header.Set("Content-Type", "application/merge-patch+json")
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPatch, "/users/{id}", uh.Patch)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusOK)
+		if tx.runs != 1 || tx.errs[0] != nil {
+			t.Fatalf("expected a single committed transaction, got %d runs with %v", tx.runs, tx.errs)
+		}
+	})
+
+	t.Run("expect PATCH /users/{id} to roll back the transaction when the update fails", func(t *testing.T) {
+		tx := &txRunnerMock{}
+		mock := newUserRepoMockDefault()
+		mock.findByIDImpl = stored
+		mock.updateImpl = func(user *models.User) (*models.User, error) {
+			return nil, &repositories.ConflictError{Message: "[email] already exists with this value (jane@gosrv.com)"}
+		}
+		uh := NewUsersHandler(mock, WithTxRunner(tx))
+
+		r := httptest.NewRequest("PATCH", "/users/1", strings.NewReader(`{"email": "jane@gosrv.com"}`))
+		r.Header.Set("Content-Type", "application/merge-patch+json")
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPatch, "/users/{id}", uh.Patch)
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		assertStatusCode(t, resp, http.StatusConflict)
+		if tx.runs != 1 || tx.errs[0] == nil {
+			t.Fatalf("expected a single rolled back transaction, got %d runs with %v", tx.runs, tx.errs)
+		}
+	})
+}
+
+func TestUsersHandler_RequestID(t *testing.T) {
+	t.Run("expect user errors to carry the request ID", func(t *testing.T) {
+		uh := NewUsersHandler(newUserRepoMockDefault())
+
+		r := httptest.NewRequest(http.MethodGet, "/users/1?include_deleted=maybe", nil)
+		r = r.WithContext(reqctx.WithRequestID(r.Context(), "req-1"))
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodGet, "/users/{id}", uh.GetByID)
+		router.ServeHTTP(w, r)
+
+		resp := w.Result()
+		assertStatusCode(t, resp, http.StatusBadRequest)
+
+		var body struct {
+			RequestID string `json:"request_id"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.RequestID != "req-1" {
+			t.Fatalf("expected request ID req-1, got %q (%v)", body.RequestID, err)
+		}
+	})
+
+	t.Run("expect internal errors to carry the request ID", func(t *testing.T) {
+		mock := newUserRepoMockDefault()
+		mock.findByIDImpl = func(ID string, includeDeleted bool) (*models.User, error) {
+			return nil, errors.New("db down")
+		}
+		uh := NewUsersHandler(mock)
+
+		r := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+		r = r.WithContext(reqctx.WithRequestID(r.Context(), "req-2"))
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodGet, "/users/{id}", uh.GetByID)
+		router.ServeHTTP(w, r)
+
+		resp := w.Result()
+		assertStatusCode(t, resp, http.StatusInternalServerError)
+
+		var body problem.Problem
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.RequestID != "req-2" {
+			t.Fatalf("expected request ID req-2, got %q (%v)", body.RequestID, err)
+		}
+	})
+}
+
+func TestUsersHandler_RequestBodies(t *testing.T) {
+	cases := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		message     string
+	}{
+		{"unknown field", "application/json", `{"name":"John Doe","email":"johndoe@gosrv.com","admin":true}`, http.StatusBadRequest, "admin: unknown field"},
+		{"trailing data", "application/json", `{"name":"John Doe","email":"johndoe@gosrv.com"} {}`, http.StatusBadRequest, "body: must hold a single JSON value"},
+		{"syntax error", "application/json", `{"name":"John Doe",}`, http.StatusBadRequest, "body: invalid JSON at byte offset 20"},
+		{"type error", "application/json", `{"name":42}`, http.StatusBadRequest, "name: must be a string, at byte offset 10"},
+		{"empty body", "", ``, http.StatusBadRequest, "body: must not be empty"},
+		{"truncated body", "", `{"name":"John`, http.StatusBadRequest, "body: truncated JSON"},
+		{"too large", "", `{"name":"` + strings.Repeat("a", 100) + `"}`, http.StatusRequestEntityTooLarge, "body: must not exceed 64 bytes"},
+		{"not JSON", "text/plain", `name=John`, http.StatusUnsupportedMediaType, "content type must be application/json"},
+	}
+
+	for _, c := range cases {
+		t.Run("expect POST /users to return "+strconv.Itoa(c.status)+" for "+c.name, func(t *testing.T) {
+			uh := NewUsersHandler(newUserRepoMockDefault(), WithMaxBodySize(64))
+
+			r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(c.body))
+			if c.contentType != "" {
+				r.Header.Set("Content-Type", c.contentType)
+			}
+			w := httptest.NewRecorder()
+			router := prepareRouter(http.MethodPost, "/users", uh.Create)
+			router.ServeHTTP(w, r)
+
+			resp := w.Result()
+			assertStatusCode(t, resp, c.status)
+			assertProblemContentType(t, resp)
+
+			var body problem.Problem
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || len(body.Errors) != 1 || body.Detail != c.message {
+				t.Fatalf("expected error %q, got %+v (%v)", c.message, body, err)
+			}
+		})
+	}
+
+	t.Run("expect PATCH /users/{id} to return 413 for a body too large", func(t *testing.T) {
+		uh := NewUsersHandler(newUserRepoMockDefault(), WithMaxBodySize(16))
+
+		r := httptest.NewRequest(http.MethodPatch, "/users/1", strings.NewReader(`{"name":"John Doe Junior"}`))
+		r.Header.Set("Content-Type", "application/merge-patch+json")
+		w := httptest.NewRecorder()
+		router := prepareRouter(http.MethodPatch, "/users/{id}", uh.Patch)
+		router.ServeHTTP(w, r)
+
+		assertStatusCode(t, w.Result(), http.StatusRequestEntityTooLarge)
+	})
+}
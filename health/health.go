@@ -0,0 +1,157 @@
+// Package health serves the liveness and readiness endpoints of the server
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Checker checks that a dependency of the server is usable
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc adapts a function to a Checker
+type CheckerFunc func(ctx context.Context) error
+
+// Check calls f(ctx)
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// CheckResult is the outcome of a single check
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the body of the readiness endpoint
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+const (
+	statusOK          = "ok"
+	statusUnavailable = "unavailable"
+)
+
+// Health runs the registered checks on behalf of the readiness endpoint.
+// Checks run concurrently, each bounded by the timeout.
+type Health struct {
+	timeout      time.Duration
+	shuttingDown atomic.Bool
+
+	mu       sync.RWMutex
+	checkers map[string]Checker
+}
+
+// New returns a Health with no checks, running them with the given timeout
+func New(timeout time.Duration) *Health {
+	return &Health{
+		timeout:  timeout,
+		checkers: map[string]Checker{},
+	}
+}
+
+// Register adds a check to the readiness endpoint, replacing any check of the same name
+func (h *Health) Register(name string, checker Checker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checkers[name] = checker
+}
+
+// Shutdown makes the readiness endpoint report the server as unavailable from now on,
+// so that it is taken out of rotation while in-flight requests complete
+func (h *Health) Shutdown() {
+	h.shuttingDown.Store(true)
+}
+
+// Check runs every registered check and reports whether the server is ready
+func (h *Health) Check(ctx context.Context) Report {
+	h.mu.RLock()
+	names := make([]string, 0, len(h.checkers))
+	for name := range h.checkers {
+		names = append(names, name)
+	}
+	checkers := make([]Checker, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		checkers[i] = h.checkers[name]
+	}
+	h.mu.RUnlock()
+
+	results := make([]CheckResult, len(names))
+	var wg sync.WaitGroup
+	for i := range checkers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = h.run(ctx, checkers[i])
+		}(i)
+	}
+	wg.Wait()
+
+	report := Report{Status: statusOK, Checks: map[string]CheckResult{}}
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		if results[i].Status != statusOK {
+			report.Status = statusUnavailable
+		}
+	}
+	if h.shuttingDown.Load() {
+		report.Status = statusUnavailable
+	}
+	return report
+}
+
+// run runs a single check, bounded by the timeout
+func (h *Health) run(ctx context.Context, checker Checker) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := checker.Check(ctx)
+	result := CheckResult{
+		Status:    statusOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = statusUnavailable
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// LivenessHandler reports that the process is up, without checking any dependency
+func (h *Health) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respond(w, map[string]string{"status": statusOK}, http.StatusOK)
+	})
+}
+
+// ReadinessHandler runs the checks, responding 200 if the server is ready or 503 otherwise
+func (h *Health) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := h.Check(r.Context())
+
+		code := http.StatusOK
+		if report.Status != statusOK {
+			code = http.StatusServiceUnavailable
+		}
+		respond(w, report, code)
+	})
+}
+
+func respond(w http.ResponseWriter, data interface{}, code int) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(data)
+}
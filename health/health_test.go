@@ -0,0 +1,81 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func readiness(t *testing.T, h *Health) (int, Report) {
+	w := httptest.NewRecorder()
+	h.ReadinessHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var report Report
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("failed to parse readiness report: %v", err)
+	}
+	return w.Code, report
+}
+
+func TestLivenessHandler(t *testing.T) {
+	h := New(time.Second)
+	h.Register("failing", CheckerFunc(func(ctx context.Context) error { return errors.New("down") }))
+
+	w := httptest.NewRecorder()
+	h.LivenessHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected liveness to ignore checks, got %d", w.Code)
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	t.Run("expect 200 when every check passes", func(t *testing.T) {
+		h := New(time.Second)
+		h.Register("database", CheckerFunc(func(ctx context.Context) error { return nil }))
+
+		code, report := readiness(t, h)
+		if code != http.StatusOK || report.Status != "ok" || report.Checks["database"].Status != "ok" {
+			t.Fatalf("expected a ready report, got %d %+v", code, report)
+		}
+	})
+
+	t.Run("expect 503 with the error of a failing check", func(t *testing.T) {
+		h := New(time.Second)
+		h.Register("database", CheckerFunc(func(ctx context.Context) error { return nil }))
+		h.Register("migrations", CheckerFunc(func(ctx context.Context) error { return errors.New("2 pending migrations") }))
+
+		code, report := readiness(t, h)
+		if code != http.StatusServiceUnavailable || report.Status != "unavailable" {
+			t.Fatalf("expected an unavailable report, got %d %+v", code, report)
+		}
+		if report.Checks["database"].Status != "ok" || report.Checks["migrations"].Error != "2 pending migrations" {
+			t.Fatalf("unexpected checks %+v", report.Checks)
+		}
+	})
+
+	t.Run("expect slow checks to time out", func(t *testing.T) {
+		h := New(10 * time.Millisecond)
+		h.Register("database", CheckerFunc(func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}))
+
+		code, report := readiness(t, h)
+		if code != http.StatusServiceUnavailable || report.Checks["database"].Error != context.DeadlineExceeded.Error() {
+			t.Fatalf("expected the check to time out, got %d %+v", code, report)
+		}
+	})
+
+	t.Run("expect 503 once shutting down", func(t *testing.T) {
+		h := New(time.Second)
+		h.Shutdown()
+
+		if code, _ := readiness(t, h); code != http.StatusServiceUnavailable {
+			t.Fatalf("expected 503 while shutting down, got %d", code)
+		}
+	})
+}
@@ -1,14 +1,54 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"github.com/s1moe2/gosrv/buildinfo"
+	"github.com/s1moe2/gosrv/config"
 	"github.com/s1moe2/gosrv/server"
 	"os"
 )
 
+const usage = `usage: gosrv [flags] [command]
+
+commands:
+  (none)        run the API server
+  migrate       manage database migrations, see gosrv migrate
+  config print  print the configuration, with secrets redacted
+  version       print the version of the build
+
+Run gosrv -h for the list of flags.`
+
 func main() {
-	if err := server.Run(); err != nil {
+	if err := run(os.Args[1:]); err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
 }
+
+func run(args []string) error {
+	conf, rest, err := config.Load(args)
+	if err != nil {
+		return err
+	}
+
+	if len(rest) == 0 {
+		// reloads read the same file, environment and flags again
+		return server.Run(conf, func() (*config.AppConfig, error) {
+			conf, _, err := config.Load(args)
+			return conf, err
+		})
+	}
+
+	switch {
+	case rest[0] == "migrate":
+		return server.Migrate(conf, rest[1:], os.Stdout)
+	case rest[0] == "config" && len(rest) == 2 && rest[1] == "print":
+		return config.Print(conf, os.Stdout)
+	case rest[0] == "version":
+		fmt.Println(buildinfo.Get())
+		return nil
+	default:
+		return errors.New(usage)
+	}
+}
@@ -0,0 +1,34 @@
+// Package migrations embeds the SQL migrations of each supported database driver
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+)
+
+//go:embed *.sql
+var postgres embed.FS
+
+//go:embed sqlite/*.sql
+var sqlite embed.FS
+
+// Dir returns the directory holding the migrations of a driver, relative to the repository root
+func Dir(driver string) string {
+	if driver == "sqlite" {
+		return "migrations/sqlite"
+	}
+	return "migrations"
+}
+
+// FS returns the embedded migrations of a driver
+func FS(driver string) (fs.FS, error) {
+	switch driver {
+	case "postgres":
+		return postgres, nil
+	case "sqlite":
+		return fs.Sub(sqlite, "sqlite")
+	default:
+		return nil, fmt.Errorf("no migrations for driver %q", driver)
+	}
+}
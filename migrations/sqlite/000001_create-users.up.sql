@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS users (
+    id    INTEGER PRIMARY KEY AUTOINCREMENT,
+    name  TEXT NOT NULL,
+    email TEXT NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (email);
@@ -0,0 +1,12 @@
+-- DROP COLUMN needs SQLite 3.35, so the table is rebuilt without the column instead
+CREATE TABLE users_old (
+    id    INTEGER PRIMARY KEY AUTOINCREMENT,
+    name  TEXT NOT NULL,
+    email TEXT NOT NULL
+);
+
+INSERT INTO users_old (id, name, email) SELECT id, name, email FROM users;
+DROP TABLE users;
+ALTER TABLE users_old RENAME TO users;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (email);
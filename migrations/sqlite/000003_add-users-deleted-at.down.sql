@@ -0,0 +1,16 @@
+-- soft deleted users can't be kept, they would break the unique index
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+
+-- DROP COLUMN needs SQLite 3.35, so the table is rebuilt without the column instead
+CREATE TABLE users_old (
+    id      INTEGER PRIMARY KEY AUTOINCREMENT,
+    name    TEXT NOT NULL,
+    email   TEXT NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+INSERT INTO users_old (id, name, email, version) SELECT id, name, email, version FROM users;
+DROP TABLE users;
+ALTER TABLE users_old RENAME TO users;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (email);
@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN deleted_at DATETIME;
+
+-- deleted users keep their email, so uniqueness only applies to the others
+DROP INDEX IF EXISTS users_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_active_key ON users (email) WHERE deleted_at IS NULL;
@@ -0,0 +1,52 @@
+package models
+
+// FilterOp identifies how a Filter value is compared against a field
+type FilterOp string
+
+const (
+	// FilterEq matches fields equal to the filter value
+	FilterEq FilterOp = "="
+	// FilterContains matches fields containing the filter value, case insensitive
+	FilterContains FilterOp = "~="
+)
+
+// Filter restricts a listing to the records whose Field matches Value according to Op
+type Filter struct {
+	Field string
+	Op    FilterOp
+	Value string
+}
+
+// SortField orders a listing by Field, ascending unless Desc is set
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// ListOptions holds the pagination, sorting and filtering options of a listing
+type ListOptions struct {
+	Limit          int
+	Offset         int
+	Sort           []SortField
+	Filters        []Filter
+	IncludeDeleted bool
+}
+
+// Keyset identifies a row of a listing by its sort column value and primary key
+type Keyset struct {
+	Value string
+	ID    string
+}
+
+// SeekOptions holds the options of a keyset paginated listing. Rows are always
+// returned in Sort order: those following Keyset, or preceding it when Backward
+// is set. A nil Keyset starts from the beginning of the listing, or from its
+// end when Backward is set.
+type SeekOptions struct {
+	Limit          int
+	Sort           SortField
+	Filters        []Filter
+	IncludeDeleted bool
+	Keyset         *Keyset
+	Backward       bool
+}
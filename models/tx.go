@@ -0,0 +1,10 @@
+package models
+
+import "context"
+
+// TxRunner runs functions atomically: every repository call made with the context
+// passed to fn takes part in the same transaction, which is committed if fn returns
+// no error and rolled back otherwise. Calls can be nested.
+type TxRunner interface {
+	RunInTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
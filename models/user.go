@@ -1,20 +1,31 @@
 package models
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // User model
 type User struct {
-	ID    string `json:"id" db:"id"`
-	Name  string `json:"name" db:"name"`
-	Email string `json:"email" db:"email"`
+	ID        string     `json:"id" db:"id"`
+	Name      string     `json:"name" db:"name"`
+	Email     string     `json:"email" db:"email"`
+	Version   int64      `json:"version" db:"version"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
 }
 
-// UserRepository defines the set of User related methods available
+// UserRepository defines the set of User related methods available.
+// Deleted users are only soft deleted: they are left out of lookups unless explicitly
+// included, can be restored, and are only removed for good when purged.
 type UserRepository interface {
 	GetAll(ctx context.Context) ([]*User, error)
-	FindByID(ctx context.Context, ID string) (*User, error)
+	List(ctx context.Context, opts ListOptions) ([]*User, int, error)
+	Seek(ctx context.Context, opts SeekOptions) ([]*User, error)
+	FindByID(ctx context.Context, ID string, includeDeleted bool) (*User, error)
 	FindByEmail(ctx context.Context, email string) (*User, error)
-	Create(user *User) (*User, error)
-	Update(user *User) (*User, error)
-	Delete(ID string) (bool, error)
+	Create(ctx context.Context, user *User) (*User, error)
+	Update(ctx context.Context, user *User) (*User, error)
+	Delete(ctx context.Context, ID string, version int64) (bool, error)
+	Restore(ctx context.Context, ID string) (*User, error)
+	Purge(ctx context.Context, ID string) (bool, error)
 }
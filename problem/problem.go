@@ -0,0 +1,81 @@
+// Package problem writes error responses as problem details, the JSON format of RFC 7807,
+// so that every error of the API has the same shape whichever layer it comes from
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/s1moe2/gosrv/reqctx"
+)
+
+// ContentType is the media type of problem details
+const ContentType = "application/problem+json"
+
+// Problem describes an error. Besides the members defined by RFC 7807, it carries the
+// errors found in the request, such as invalid fields, and the ID of the request.
+type Problem struct {
+	// Type is a URI identifying the type of problem, about:blank when it is
+	// described by the status code alone
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	Errors    []FieldError `json:"errors,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// FieldError is an error about a single part of a request, such as a body field or a
+// query parameter, named by Field when known. Code identifies the failed validation rule,
+// for clients to act on, when the error comes from one.
+type FieldError struct {
+	Field  string `json:"field,omitempty"`
+	Code   string `json:"code,omitempty"`
+	Detail string `json:"detail"`
+}
+
+// New returns a Problem of type about:blank for the given status, titled after it
+func New(status int, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// ParseFieldError returns the FieldError of an error message written as "field: detail",
+// the convention of the API, or one without field for other messages
+func ParseFieldError(msg string) FieldError {
+	field, detail, ok := strings.Cut(msg, ": ")
+	if !ok || field == "" || strings.ContainsAny(field, " \t") {
+		return FieldError{Detail: msg}
+	}
+	return FieldError{Field: field, Detail: detail}
+}
+
+// Write responds with the problem, which is about the request r and tagged with its ID
+func (p *Problem) Write(w http.ResponseWriter, r *http.Request) error {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if p.RequestID == "" {
+		p.RequestID = reqctx.RequestID(r.Context())
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Del("Content-Length")
+	w.WriteHeader(p.Status)
+	return json.NewEncoder(w).Encode(p)
+}
+
+// Handler returns a handler responding with a problem of the given status, such as
+// for routes that are not found
+func Handler(status int, detail string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		New(status, detail).Write(w, r)
+	})
+}
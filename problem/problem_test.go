@@ -0,0 +1,75 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/s1moe2/gosrv/reqctx"
+)
+
+func TestProblem_Write(t *testing.T) {
+	p := New(http.StatusBadRequest, "email: invalid format")
+	p.Errors = []FieldError{ParseFieldError("email: invalid format")}
+
+	r := httptest.NewRequest(http.MethodPost, "/users?x=1", nil)
+	r = r.WithContext(reqctx.WithRequestID(r.Context(), "req-1"))
+	w := httptest.NewRecorder()
+	if err := p.Write(w, r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if w.Code != http.StatusBadRequest || w.Header().Get("Content-Type") != ContentType {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	expected := map[string]interface{}{
+		"type":       "about:blank",
+		"title":      "Bad Request",
+		"status":     float64(400),
+		"detail":     "email: invalid format",
+		"instance":   "/users",
+		"request_id": "req-1",
+	}
+	for k, v := range expected {
+		if body[k] != v {
+			t.Fatalf("expected %s to be %v, got %v", k, v, body[k])
+		}
+	}
+	errs := body["errors"].([]interface{})
+	if field := errs[0].(map[string]interface{})["field"]; len(errs) != 1 || field != "email" {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+}
+
+func TestParseFieldError(t *testing.T) {
+	cases := map[string]FieldError{
+		"name: invalid length":                 {Field: "name", Detail: "invalid length"},
+		"patch[0]: path: invalid JSON pointer": {Field: "patch[0]", Detail: "path: invalid JSON pointer"},
+		"email already in use":                 {Detail: "email already in use"},
+		"invalid sort: must be a field":        {Detail: "invalid sort: must be a field"},
+	}
+	for msg, expected := range cases {
+		if fe := ParseFieldError(msg); fe != expected {
+			t.Fatalf("%q: expected %+v, got %+v", msg, expected, fe)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	Handler(http.StatusNotFound, "no resource matches the request path").ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/nope", nil))
+
+	var p Problem
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	if w.Code != http.StatusNotFound || p.Title != "Not Found" || p.Instance != "/nope" {
+		t.Fatalf("unexpected problem %d %+v", w.Code, p)
+	}
+}
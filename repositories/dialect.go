@@ -0,0 +1,30 @@
+package repositories
+
+// dialect holds the SQL differences between the supported database drivers.
+// Statements are written with '?' placeholders and rebound to the driver's bind type by sqlx.
+type dialect struct {
+	// system is the name of the database in traces
+	system string
+	// like is the case insensitive LIKE operator
+	like string
+	// noLimit is the LIMIT value that doesn't limit rows, needed before an OFFSET
+	noLimit string
+	// returning tells whether INSERT and UPDATE statements support a RETURNING clause,
+	// which SQLite only does from 3.35
+	returning bool
+}
+
+var (
+	postgresDialect = dialect{system: "postgresql", like: "ILIKE", noLimit: "ALL", returning: true}
+	sqliteDialect   = dialect{system: "sqlite", like: "LIKE", noLimit: "-1", returning: false}
+)
+
+// dialectFor returns the dialect of a database/sql driver name
+func dialectFor(driverName string) dialect {
+	switch driverName {
+	case "sqlite", "sqlite3":
+		return sqliteDialect
+	default:
+		return postgresDialect
+	}
+}
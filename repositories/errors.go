@@ -1,11 +1,24 @@
 package repositories
 
 import (
+	"context"
+	"database/sql/driver"
 	"fmt"
 	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"net"
 	"regexp"
+	"strconv"
 )
 
+// The errors below make up the taxonomy of repository errors, whatever the database, for
+// callers to tell apart the failures they can report from internal ones. They wrap the
+// error of the database driver, if any.
+
+// ConflictError is returned when a write conflicts with the stored records, such as
+// a value that must be unique, or with a concurrent transaction
 type ConflictError struct {
 	Message string
 	Err     error
@@ -15,7 +28,124 @@ func (e *ConflictError) Error() string {
 	return e.Message
 }
 
-const PQUniqueViolation = "23505"
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// StaleVersionError is returned when a write expected a version of a record
+// that is no longer the current one
+type StaleVersionError struct {
+	Message string
+}
+
+func (e *StaleVersionError) Error() string {
+	return e.Message
+}
+
+// NotFoundError is returned when a record a statement required doesn't exist. Lookups
+// don't return it, they return no record instead.
+type NotFoundError struct {
+	Message string
+	Err     error
+}
+
+func (e *NotFoundError) Error() string {
+	return e.Message
+}
+
+func (e *NotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// ForeignKeyError is returned when a write references a record that doesn't exist,
+// or deletes one that is still referenced
+type ForeignKeyError struct {
+	Message string
+	Err     error
+}
+
+func (e *ForeignKeyError) Error() string {
+	return e.Message
+}
+
+func (e *ForeignKeyError) Unwrap() error {
+	return e.Err
+}
+
+// CheckViolationError is returned when a write breaks a check or not null constraint
+type CheckViolationError struct {
+	Message string
+	Err     error
+}
+
+func (e *CheckViolationError) Error() string {
+	return e.Message
+}
+
+func (e *CheckViolationError) Unwrap() error {
+	return e.Err
+}
+
+// TimeoutError is returned when a statement is cancelled for running out of time
+type TimeoutError struct {
+	Message string
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	return e.Message
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// UnavailableError is returned when the database can't serve statements for now, such as
+// when it is unreachable, shutting down or locked, and the statement may be retried later
+type UnavailableError struct {
+	Message string
+	Err     error
+}
+
+func (e *UnavailableError) Error() string {
+	return e.Message
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// newEmailConflictError returns a ConflictError for an email that is already in use
+func newEmailConflictError(email string) *ConflictError {
+	return &ConflictError{
+		Message: fmt.Sprintf("[email] already exists with this value (%s)", email),
+	}
+}
+
+// newStaleVersionError returns a StaleVersionError for a write that expected the given version
+func newStaleVersionError(version int64) *StaleVersionError {
+	return &StaleVersionError{
+		Message: "user has been modified since version " + strconv.FormatInt(version, 10),
+	}
+}
+
+// SQLSTATE codes of the PostgreSQL errors with a matching custom error
+const (
+	PQUniqueViolation      = "23505"
+	PQForeignKeyViolation  = "23503"
+	PQCheckViolation       = "23514"
+	PQNotNullViolation     = "23502"
+	PQSerializationFailure = "40001"
+	PQDeadlockDetected     = "40P01"
+	PQQueryCanceled        = "57014"
+	PQAdminShutdown        = "57P01"
+	PQCrashShutdown        = "57P02"
+	PQCannotConnectNow     = "57P03"
+	PQTooManyConnections   = "53300"
+
+	// pqConnectionException is the class of the errors about the connection to the database
+	pqConnectionException = "08"
+)
 
 // parsePsqlError takes a pq.Error and returns a matching custom error
 // or the error itself if no matching custom error exists
@@ -29,9 +159,95 @@ func parsePsqlError(e *pq.Error) error {
 			Message: msg,
 			Err:     e,
 		}
-	default:
-		return e
+	case PQSerializationFailure, PQDeadlockDetected:
+		return &ConflictError{
+			Message: "conflicts with a concurrent transaction",
+			Err:     e,
+		}
+	case PQForeignKeyViolation:
+		column, value := extractColumnValue(e.Detail)
+		msg := fmt.Sprintf("[%s] references a missing record (%s)", column, value)
+		if column == "" {
+			msg = "is still referenced by other records"
+		}
+
+		return &ForeignKeyError{
+			Message: msg,
+			Err:     e,
+		}
+	case PQCheckViolation:
+		return &CheckViolationError{
+			Message: fmt.Sprintf("violates the constraint %s", e.Constraint),
+			Err:     e,
+		}
+	case PQNotNullViolation:
+		return &CheckViolationError{
+			Message: fmt.Sprintf("[%s] can't be null", e.Column),
+			Err:     e,
+		}
+	case PQQueryCanceled:
+		return &TimeoutError{
+			Message: "statement timed out",
+			Err:     e,
+		}
+	case PQAdminShutdown, PQCrashShutdown, PQCannotConnectNow, PQTooManyConnections:
+		return &UnavailableError{
+			Message: "database unavailable",
+			Err:     e,
+		}
+	}
+
+	if e.Code.Class() == pqConnectionException {
+		return &UnavailableError{
+			Message: "database unavailable",
+			Err:     e,
+		}
 	}
+	return e
+}
+
+// parseSqliteError takes a sqlite.Error and returns a matching custom error
+// or the error itself if no matching custom error exists
+func parseSqliteError(e *sqlite.Error) error {
+	switch e.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		// SQLite doesn't report the conflicting value, only the column
+		column := extractSqliteColumn(e.Error())
+		msg := fmt.Sprintf("[%s] already exists with this value", column)
+
+		return &ConflictError{
+			Message: msg,
+			Err:     e,
+		}
+	case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+		// nor does it report the column of foreign keys
+		return &ForeignKeyError{
+			Message: "references a missing record, or is still referenced",
+			Err:     e,
+		}
+	case sqlite3.SQLITE_CONSTRAINT_CHECK:
+		return &CheckViolationError{
+			Message: "violates a check constraint",
+			Err:     e,
+		}
+	case sqlite3.SQLITE_CONSTRAINT_NOTNULL:
+		column := extractSqliteColumn(e.Error())
+
+		return &CheckViolationError{
+			Message: fmt.Sprintf("[%s] can't be null", column),
+			Err:     e,
+		}
+	}
+
+	// the primary code of extended codes, such as SQLITE_BUSY_SNAPSHOT, is their low byte
+	switch e.Code() & 0xff {
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return &UnavailableError{
+			Message: "database locked",
+			Err:     e,
+		}
+	}
+	return e
 }
 
 // parseError take an error and passes it through the respective database error parser
@@ -42,6 +258,26 @@ func parseError(e error) error {
 		return parsePsqlError(pqErr)
 	}
 
+	sqliteErr, ok := e.(*sqlite.Error)
+	if ok {
+		return parseSqliteError(sqliteErr)
+	}
+
+	if errors.Is(e, context.DeadlineExceeded) {
+		return &TimeoutError{
+			Message: "statement timed out",
+			Err:     e,
+		}
+	}
+
+	var netErr net.Error
+	if errors.Is(e, driver.ErrBadConn) || errors.As(e, &netErr) {
+		return &UnavailableError{
+			Message: "database unavailable",
+			Err:     e,
+		}
+	}
+
 	return e
 }
 
@@ -57,6 +293,14 @@ func extractColumnValue(detail string) (string, string) {
 	return column, value
 }
 
+// extractSqliteColumn takes a string in the form of a sqlite constraint error message
+// and returns the contained column, without its table
+func extractSqliteColumn(msg string) string {
+	var columnFinder = regexp.MustCompile(`constraint failed: \w+\.(\w+)`)
+
+	return extractStringSubmatch(columnFinder, msg)
+}
+
 // extractString takes a regex and a string and returns
 // the matched string or an empty string if no match exists
 func extractStringSubmatch(regex *regexp.Regexp, str string) string {
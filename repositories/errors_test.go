@@ -0,0 +1,46 @@
+package repositories
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+func TestParseError(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		expected error
+		msg      string
+	}{
+		{"unique", &pq.Error{Code: PQUniqueViolation, Detail: "Key (email)=(a@b.c) already exists."},
+			&ConflictError{}, "[email] already exists with this value (a@b.c)"},
+		{"serialization", &pq.Error{Code: PQSerializationFailure}, &ConflictError{}, "conflicts with a concurrent transaction"},
+		{"foreign key", &pq.Error{Code: PQForeignKeyViolation, Detail: `Key (user_id)=(5) is not present in table "users".`},
+			&ForeignKeyError{}, "[user_id] references a missing record (5)"},
+		{"check", &pq.Error{Code: PQCheckViolation, Constraint: "users_name_check"},
+			&CheckViolationError{}, "violates the constraint users_name_check"},
+		{"not null", &pq.Error{Code: PQNotNullViolation, Column: "name"}, &CheckViolationError{}, "[name] can't be null"},
+		{"query canceled", &pq.Error{Code: PQQueryCanceled}, &TimeoutError{}, "statement timed out"},
+		{"shutdown", &pq.Error{Code: PQAdminShutdown}, &UnavailableError{}, "database unavailable"},
+		{"connection", &pq.Error{Code: "08006"}, &UnavailableError{}, "database unavailable"},
+		{"deadline", errors.Wrap(context.DeadlineExceeded, "query"), &TimeoutError{}, "statement timed out"},
+		{"bad connection", driver.ErrBadConn, &UnavailableError{}, "database unavailable"},
+		{"other", &pq.Error{Code: "42601"}, &pq.Error{}, "pq: "},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := parseError(c.err)
+			if reflect.TypeOf(err) != reflect.TypeOf(c.expected) || err.Error() != c.msg {
+				t.Fatalf("expected a %T with message %q, got %T: %v", c.expected, c.msg, err, err)
+			}
+			if _, ok := c.expected.(*pq.Error); !ok && errors.Unwrap(err) != c.err {
+				t.Fatalf("expected the error to wrap %v", c.err)
+			}
+		})
+	}
+}
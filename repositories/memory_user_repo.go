@@ -0,0 +1,468 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/s1moe2/gosrv/models"
+)
+
+// MemoryUserRepo implements models.UserRepository in memory, following the same
+// semantics as UserRepo: numeric string IDs, unique emails among users that aren't
+// deleted, nil results when not found and versioned, soft deleted users.
+// It is safe for concurrent use.
+type MemoryUserRepo struct {
+	mu     sync.RWMutex
+	users  map[int64]*models.User
+	lastID int64
+}
+
+// memorySnapshot is the JSON representation of a MemoryUserRepo
+type memorySnapshot struct {
+	LastID int64          `json:"last_id"`
+	Users  []*models.User `json:"users"`
+}
+
+// NewMemoryUserRepo returns an empty MemoryUserRepo object
+func NewMemoryUserRepo() *MemoryUserRepo {
+	return &MemoryUserRepo{
+		users: map[int64]*models.User{},
+	}
+}
+
+// LoadFile replaces the users in the repository with the ones in a snapshot file.
+// A missing file is not an error and leaves the repository empty.
+func (r *MemoryUserRepo) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "failed to read snapshot")
+	}
+
+	var snapshot memorySnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return errors.Wrap(err, "failed to parse snapshot")
+	}
+
+	users := make(map[int64]*models.User, len(snapshot.Users))
+	for _, user := range snapshot.Users {
+		id, err := strconv.ParseInt(user.ID, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid user id %q in snapshot", user.ID)
+		}
+		users[id] = user
+		if id > snapshot.LastID {
+			snapshot.LastID = id
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users = users
+	r.lastID = snapshot.LastID
+	return nil
+}
+
+// SaveFile writes a snapshot of the users in the repository to a file, atomically
+// replacing it so that a crash can't leave a truncated snapshot behind
+func (r *MemoryUserRepo) SaveFile(path string) error {
+	r.mu.RLock()
+	snapshot := memorySnapshot{LastID: r.lastID, Users: r.sorted(r.filter(nil, true), nil, false)}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	r.mu.RUnlock()
+	if err != nil {
+		return errors.Wrap(err, "failed to encode snapshot")
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrap(err, "failed to write snapshot")
+	}
+	return errors.Wrap(os.Rename(tmp, path), "failed to write snapshot")
+}
+
+// GetAll fetches all users that aren't deleted, returns an empty slice if no user exists
+func (r *MemoryUserRepo) GetAll(ctx context.Context) ([]*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return copyUsers(r.sorted(r.filter(nil, false), nil, false)), nil
+}
+
+// List fetches a page of users matching the given options, along with the total
+// number of users matching the filters regardless of pagination
+func (r *MemoryUserRepo) List(ctx context.Context, opts models.ListOptions) ([]*models.User, int, error) {
+	if err := checkUserFields(opts.Filters, opts.Sort); err != nil {
+		return nil, 0, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := r.sorted(r.filter(opts.Filters, opts.IncludeDeleted), opts.Sort, false)
+	total := len(users)
+
+	if opts.Offset >= len(users) {
+		users = nil
+	} else {
+		users = users[opts.Offset:]
+	}
+	if opts.Limit > 0 && opts.Limit < len(users) {
+		users = users[:opts.Limit]
+	}
+
+	return copyUsers(users), total, nil
+}
+
+// Seek fetches a page of users following (or preceding) a keyset, ordered by
+// the sort field and then by ID
+func (r *MemoryUserRepo) Seek(ctx context.Context, opts models.SeekOptions) ([]*models.User, error) {
+	if err := checkUserFields(opts.Filters, []models.SortField{opts.Sort}); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// scanning backward walks the listing in the opposite order and the
+	// results are reversed afterwards to keep them in sort order.
+	// Like the SQL keyset queries, ties are broken by ID in the same direction.
+	order := models.SortField{Field: opts.Sort.Field, Desc: opts.Sort.Desc != opts.Backward}
+	users := r.sorted(r.filter(opts.Filters, opts.IncludeDeleted), []models.SortField{order}, order.Desc)
+
+	page := []*models.User{}
+	for _, user := range users {
+		if len(page) == opts.Limit {
+			break
+		}
+		if opts.Keyset == nil || compareKeyset(user, order, opts.Keyset) > 0 {
+			page = append(page, user)
+		}
+	}
+
+	if opts.Backward {
+		for i, j := 0, len(page)-1; i < j; i, j = i+1, j-1 {
+			page[i], page[j] = page[j], page[i]
+		}
+	}
+
+	return copyUsers(page), nil
+}
+
+// FindByID finds a user by ID, returns nil if not found or deleted, unless includeDeleted is set
+func (r *MemoryUserRepo) FindByID(ctx context.Context, ID string, includeDeleted bool) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user := r.find(ID)
+	if user == nil || (user.DeletedAt != nil && !includeDeleted) {
+		return nil, nil
+	}
+	return copyUser(user), nil
+}
+
+// FindByEmail finds a user that isn't deleted by email, returns nil if not found
+func (r *MemoryUserRepo) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user := r.findByEmail(email, nil)
+	if user == nil {
+		return nil, nil
+	}
+	return copyUser(user), nil
+}
+
+// Create creates a new user, returning the full model.
+// A ConflictError is returned if the email is in use.
+func (r *MemoryUserRepo) Create(ctx context.Context, user *models.User) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.findByEmail(user.Email, nil) != nil {
+		return nil, newEmailConflictError(user.Email)
+	}
+
+	r.lastID++
+	user.ID = strconv.FormatInt(r.lastID, 10)
+	user.Version = 1
+	user.DeletedAt = nil
+	r.users[r.lastID] = copyUser(user)
+
+	return user, nil
+}
+
+// Update updates a user, returning the updated model with its new version or nil if it doesn't exist.
+// When user.Version is not zero, the update only happens if it still is the current version,
+// otherwise a StaleVersionError is returned.
+func (r *MemoryUserRepo) Update(ctx context.Context, user *models.User) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := r.find(user.ID)
+	if stored == nil || stored.DeletedAt != nil {
+		return nil, nil
+	}
+	if user.Version != 0 && user.Version != stored.Version {
+		return nil, newStaleVersionError(user.Version)
+	}
+	if r.findByEmail(user.Email, stored) != nil {
+		return nil, newEmailConflictError(user.Email)
+	}
+
+	stored.Name = user.Name
+	stored.Email = user.Email
+	stored.Version++
+
+	return copyUser(stored), nil
+}
+
+// Delete soft deletes a user, returning whether it existed.
+// When version is not zero, the user is only deleted if it still is the current version,
+// otherwise a StaleVersionError is returned.
+func (r *MemoryUserRepo) Delete(ctx context.Context, ID string, version int64) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := r.find(ID)
+	if stored == nil || stored.DeletedAt != nil {
+		return false, nil
+	}
+	if version != 0 && version != stored.Version {
+		return false, newStaleVersionError(version)
+	}
+
+	now := time.Now().UTC()
+	stored.DeletedAt = &now
+	stored.Version++
+
+	return true, nil
+}
+
+// Restore restores a deleted user, returning the restored model or nil if there is no such deleted user.
+// A ConflictError is returned if the email of the user has been taken since it was deleted.
+func (r *MemoryUserRepo) Restore(ctx context.Context, ID string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := r.find(ID)
+	if stored == nil || stored.DeletedAt == nil {
+		return nil, nil
+	}
+	if r.findByEmail(stored.Email, stored) != nil {
+		return nil, newEmailConflictError(stored.Email)
+	}
+
+	stored.DeletedAt = nil
+	stored.Version++
+
+	return copyUser(stored), nil
+}
+
+// Purge permanently deletes a user, whether it is soft deleted or not, returning whether it existed
+func (r *MemoryUserRepo) Purge(ctx context.Context, ID string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := strconv.ParseInt(ID, 10, 64)
+	if err != nil || r.users[id] == nil {
+		return false, nil
+	}
+
+	delete(r.users, id)
+	return true, nil
+}
+
+// find returns the stored user with the given ID, or nil.
+// IDs that aren't numeric can't exist, like with a SERIAL column.
+func (r *MemoryUserRepo) find(ID string) *models.User {
+	id, err := strconv.ParseInt(ID, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return r.users[id]
+}
+
+// findByEmail returns the stored user that isn't deleted with the given email,
+// other than except, or nil. The stored user is excluded rather than its ID, as
+// the ID it is looked up with may be written differently, such as 01 for 1.
+func (r *MemoryUserRepo) findByEmail(email string, except *models.User) *models.User {
+	for _, user := range r.users {
+		if user.Email == email && user.DeletedAt == nil && user != except {
+			return user
+		}
+	}
+	return nil
+}
+
+// filter returns the stored users matching all filters
+func (r *MemoryUserRepo) filter(filters []models.Filter, includeDeleted bool) []*models.User {
+	var users []*models.User
+
+	for _, user := range r.users {
+		if user.DeletedAt != nil && !includeDeleted {
+			continue
+		}
+
+		match := true
+		for _, f := range filters {
+			value := userFieldValue(user, f.Field)
+			switch f.Op {
+			case models.FilterEq:
+				match = value == f.Value
+			case models.FilterContains:
+				match = strings.Contains(strings.ToLower(value), strings.ToLower(f.Value))
+			}
+			if !match {
+				break
+			}
+		}
+
+		if match {
+			users = append(users, user)
+		}
+	}
+
+	return users
+}
+
+// sorted sorts users by the given fields, and then by ID, descending if idDesc is set
+func (r *MemoryUserRepo) sorted(users []*models.User, order []models.SortField, idDesc bool) []*models.User {
+	sort.SliceStable(users, func(i, j int) bool {
+		for _, s := range order {
+			cmp := compareUserField(users[i], users[j], s.Field)
+			if s.Desc {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+
+		cmp := compareUserField(users[i], users[j], "id")
+		if idDesc {
+			cmp = -cmp
+		}
+		return cmp < 0
+	})
+	return users
+}
+
+// compareKeyset compares a user to a keyset in the given order,
+// returning a positive number if the user comes after it
+func compareKeyset(user *models.User, order models.SortField, keyset *models.Keyset) int {
+	key := &models.User{ID: keyset.ID}
+	if order.Field != "id" {
+		switch order.Field {
+		case "name":
+			key.Name = keyset.Value
+		case "email":
+			key.Email = keyset.Value
+		}
+	}
+
+	cmp := compareUserField(user, key, order.Field)
+	if cmp == 0 {
+		cmp = compareUserField(user, key, "id")
+	}
+	if order.Desc {
+		cmp = -cmp
+	}
+	return cmp
+}
+
+// compareUserField compares a field of two users, IDs being compared numerically
+func compareUserField(a *models.User, b *models.User, field string) int {
+	if field == "id" {
+		idA, _ := strconv.ParseInt(a.ID, 10, 64)
+		idB, _ := strconv.ParseInt(b.ID, 10, 64)
+		switch {
+		case idA < idB:
+			return -1
+		case idA > idB:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return strings.Compare(userFieldValue(a, field), userFieldValue(b, field))
+}
+
+func userFieldValue(user *models.User, field string) string {
+	switch field {
+	case "id":
+		return user.ID
+	case "name":
+		return user.Name
+	case "email":
+		return user.Email
+	default:
+		return ""
+	}
+}
+
+// checkUserFields validates the fields filters and sort fields refer to
+func checkUserFields(filters []models.Filter, order []models.SortField) error {
+	for _, f := range filters {
+		if _, ok := userColumns[f.Field]; !ok {
+			return fmt.Errorf("unknown filter field %q", f.Field)
+		}
+		if f.Op != models.FilterEq && f.Op != models.FilterContains {
+			return fmt.Errorf("unknown filter operator %q", f.Op)
+		}
+	}
+	for _, s := range order {
+		if _, ok := userColumns[s.Field]; !ok {
+			return fmt.Errorf("unknown sort field %q", s.Field)
+		}
+	}
+	return nil
+}
+
+func copyUser(user *models.User) *models.User {
+	cp := *user
+	if user.DeletedAt != nil {
+		deletedAt := *user.DeletedAt
+		cp.DeletedAt = &deletedAt
+	}
+	return &cp
+}
+
+func copyUsers(users []*models.User) []*models.User {
+	copies := make([]*models.User, len(users))
+	for i, user := range users {
+		copies[i] = copyUser(user)
+	}
+	return copies
+}
@@ -0,0 +1,284 @@
+package repositories
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/s1moe2/gosrv/models"
+)
+
+func seedMemoryUserRepo(t *testing.T, names ...string) *MemoryUserRepo {
+	repo := NewMemoryUserRepo()
+	for _, name := range names {
+		_, err := repo.Create(context.Background(), &models.User{Name: name, Email: name + "@gosrv.com"})
+		if err != nil {
+			t.Fatalf("failed to seed user %s: %v", name, err)
+		}
+	}
+	return repo
+}
+
+func userIDs(users []*models.User) []string {
+	ids := []string{}
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
+
+func TestMemoryUserRepo_Create(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("expect sequential string IDs and a first version", func(t *testing.T) {
+		repo := seedMemoryUserRepo(t, "ann")
+
+		user, err := repo.Create(ctx, &models.User{Name: "bob", Email: "bob@gosrv.com"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if user.ID != "2" || user.Version != 1 {
+			t.Fatalf("expected ID 2 at version 1, got %+v", user)
+		}
+	})
+
+	t.Run("expect a ConflictError when the email is in use", func(t *testing.T) {
+		repo := seedMemoryUserRepo(t, "ann")
+
+		_, err := repo.Create(ctx, &models.User{Name: "other ann", Email: "ann@gosrv.com"})
+		if _, ok := err.(*ConflictError); !ok {
+			t.Fatalf("expected a ConflictError, got %v", err)
+		}
+	})
+
+	t.Run("expect the email of a deleted user to be reusable", func(t *testing.T) {
+		repo := seedMemoryUserRepo(t, "ann")
+		if _, err := repo.Delete(ctx, "1", 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, err := repo.Create(ctx, &models.User{Name: "new ann", Email: "ann@gosrv.com"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestMemoryUserRepo_Find(t *testing.T) {
+	ctx := context.Background()
+	repo := seedMemoryUserRepo(t, "ann", "bob")
+	if _, err := repo.Delete(ctx, "2", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		name           string
+		id             string
+		includeDeleted bool
+		found          bool
+	}{
+		{"existing user", "1", false, true},
+		{"missing user", "3", false, false},
+		{"non numeric ID", "abc", false, false},
+		{"deleted user", "2", false, false},
+		{"deleted user when included", "2", true, true},
+	}
+
+	for _, c := range cases {
+		user, err := repo.FindByID(ctx, c.id, c.includeDeleted)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.name, err)
+		}
+		if (user != nil) != c.found {
+			t.Fatalf("%s: expected found to be %v, got %+v", c.name, c.found, user)
+		}
+	}
+
+	user, _ := repo.FindByEmail(ctx, "bob@gosrv.com")
+	if user != nil {
+		t.Fatalf("expected deleted users to be left out of email lookups, got %+v", user)
+	}
+
+	user, _ = repo.FindByID(ctx, "1", false)
+	user.Name = "changed"
+	stored, _ := repo.FindByID(ctx, "1", false)
+	if stored.Name != "ann" {
+		t.Fatal("expected returned users to be copies")
+	}
+}
+
+func TestMemoryUserRepo_Update(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("expect the version to be checked and bumped", func(t *testing.T) {
+		repo := seedMemoryUserRepo(t, "ann")
+
+		user, err := repo.Update(ctx, &models.User{ID: "1", Name: "anne", Email: "ann@gosrv.com", Version: 1})
+		if err != nil || user.Version != 2 {
+			t.Fatalf("expected version 2, got %+v (%v)", user, err)
+		}
+
+		_, err = repo.Update(ctx, &models.User{ID: "1", Name: "annie", Email: "ann@gosrv.com", Version: 1})
+		if _, ok := err.(*StaleVersionError); !ok {
+			t.Fatalf("expected a StaleVersionError, got %v", err)
+		}
+	})
+
+	t.Run("expect a ConflictError when taking the email of another user", func(t *testing.T) {
+		repo := seedMemoryUserRepo(t, "ann", "bob")
+
+		_, err := repo.Update(ctx, &models.User{ID: "2", Name: "bob", Email: "ann@gosrv.com"})
+		if _, ok := err.(*ConflictError); !ok {
+			t.Fatalf("expected a ConflictError, got %v", err)
+		}
+	})
+
+	t.Run("expect no conflict with its own email when the ID is written differently", func(t *testing.T) {
+		repo := seedMemoryUserRepo(t, "ann", "bob")
+
+		user, err := repo.Update(ctx, &models.User{ID: "01", Name: "anne", Email: "ann@gosrv.com"})
+		if err != nil || user.ID != "1" || user.Name != "anne" {
+			t.Fatalf("expected user 1 to be updated, got %+v (%v)", user, err)
+		}
+	})
+
+	t.Run("expect nil when the user doesn't exist", func(t *testing.T) {
+		repo := seedMemoryUserRepo(t)
+
+		user, err := repo.Update(ctx, &models.User{ID: "1", Name: "ann", Email: "ann@gosrv.com"})
+		if user != nil || err != nil {
+			t.Fatalf("expected no user and no error, got %+v (%v)", user, err)
+		}
+	})
+
+	t.Run("expect a cancelled context to abort the write", func(t *testing.T) {
+		repo := seedMemoryUserRepo(t, "ann")
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err := repo.Update(cancelled, &models.User{ID: "1", Name: "anne", Email: "ann@gosrv.com"})
+		if err != context.Canceled {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	})
+}
+
+func TestMemoryUserRepo_DeleteRestorePurge(t *testing.T) {
+	ctx := context.Background()
+	repo := seedMemoryUserRepo(t, "ann")
+
+	if deleted, err := repo.Delete(ctx, "1", 1); !deleted || err != nil {
+		t.Fatalf("expected the user to be deleted, got %v (%v)", deleted, err)
+	}
+	if deleted, _ := repo.Delete(ctx, "1", 0); deleted {
+		t.Fatal("expected a deleted user to not be deleted again")
+	}
+
+	if _, err := repo.Create(ctx, &models.User{Name: "new ann", Email: "ann@gosrv.com"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := repo.Restore(ctx, "1"); err == nil {
+		t.Fatal("expected a ConflictError restoring a user whose email was taken")
+	}
+
+	if purged, _ := repo.Purge(ctx, "2"); !purged {
+		t.Fatal("expected the user to be purged")
+	}
+	user, err := repo.Restore(ctx, "1")
+	if err != nil || user == nil || user.DeletedAt != nil {
+		t.Fatalf("expected the user to be restored, got %+v (%v)", user, err)
+	}
+}
+
+func TestMemoryUserRepo_List(t *testing.T) {
+	ctx := context.Background()
+	repo := seedMemoryUserRepo(t, "bob", "ann", "cid", "dan")
+	_, _ = repo.Delete(ctx, "4", 0)
+
+	users, total, err := repo.List(ctx, models.ListOptions{
+		Limit:   2,
+		Offset:  1,
+		Sort:    []models.SortField{{Field: "name", Desc: true}},
+		Filters: []models.Filter{{Field: "email", Op: models.FilterContains, Value: "GOSRV"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if total != 3 || !reflect.DeepEqual(userIDs(users), []string{"1", "2"}) {
+		t.Fatalf("expected users [1 2] of 3, got %v of %d", userIDs(users), total)
+	}
+
+	_, total, _ = repo.List(ctx, models.ListOptions{IncludeDeleted: true})
+	if total != 4 {
+		t.Fatalf("expected 4 users including deleted ones, got %d", total)
+	}
+
+	if _, _, err := repo.List(ctx, models.ListOptions{Sort: []models.SortField{{Field: "password"}}}); err == nil {
+		t.Fatal("expected an error sorting by an unknown field")
+	}
+}
+
+func TestMemoryUserRepo_Seek(t *testing.T) {
+	ctx := context.Background()
+	repo := seedMemoryUserRepo(t, "bob", "ann", "cid", "ann2")
+	_, _ = repo.Update(ctx, &models.User{ID: "4", Name: "ann", Email: "ann2@gosrv.com"})
+	byName := models.SortField{Field: "name"}
+
+	first, _ := repo.Seek(ctx, models.SeekOptions{Limit: 2, Sort: byName})
+	if !reflect.DeepEqual(userIDs(first), []string{"2", "4"}) {
+		t.Fatalf("expected first page [2 4], got %v", userIDs(first))
+	}
+
+	next, _ := repo.Seek(ctx, models.SeekOptions{Limit: 2, Sort: byName, Keyset: &models.Keyset{Value: "ann", ID: "4"}})
+	if !reflect.DeepEqual(userIDs(next), []string{"1", "3"}) {
+		t.Fatalf("expected next page [1 3], got %v", userIDs(next))
+	}
+
+	prev, _ := repo.Seek(ctx, models.SeekOptions{Limit: 2, Sort: byName, Keyset: &models.Keyset{Value: "bob", ID: "1"}, Backward: true})
+	if !reflect.DeepEqual(userIDs(prev), []string{"2", "4"}) {
+		t.Fatalf("expected prev page [2 4], got %v", userIDs(prev))
+	}
+
+	desc, _ := repo.Seek(ctx, models.SeekOptions{Limit: 3, Sort: models.SortField{Field: "id", Desc: true}, Keyset: &models.Keyset{ID: "4"}})
+	if !reflect.DeepEqual(userIDs(desc), []string{"3", "2", "1"}) {
+		t.Fatalf("expected descending page [3 2 1], got %v", userIDs(desc))
+	}
+}
+
+func TestMemoryUserRepo_Snapshot(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "gosrv")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.json")
+
+	repo := seedMemoryUserRepo(t, "ann", "bob", "cid")
+	_, _ = repo.Delete(ctx, "2", 0)
+	_, _ = repo.Purge(ctx, "3")
+	if err := repo.SaveFile(path); err != nil {
+		t.Fatalf("unexpected error saving: %v", err)
+	}
+
+	loaded := NewMemoryUserRepo()
+	if err := loaded.LoadFile(path); err != nil {
+		t.Fatalf("unexpected error loading: %v", err)
+	}
+
+	deleted, _ := loaded.FindByID(ctx, "2", true)
+	if deleted == nil || deleted.DeletedAt == nil {
+		t.Fatalf("expected the deleted user to be restored as deleted, got %+v", deleted)
+	}
+
+	user, _ := loaded.Create(ctx, &models.User{Name: "dan", Email: "dan@gosrv.com"})
+	if user.ID != "4" {
+		t.Fatalf("expected IDs of purged users to not be reused, got %s", user.ID)
+	}
+
+	if err := NewMemoryUserRepo().LoadFile(filepath.Join(dir, "missing.json")); err != nil {
+		t.Fatalf("expected a missing snapshot to be ignored, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/s1moe2/gosrv/repositories")
+
+// tracedConn wraps a dbConn to record a span for each statement it runs,
+// along with the number of rows it returned or affected. It also turns the errors
+// of the database into the repository errors matching them, see parseError.
+type tracedConn struct {
+	dbConn
+	system string
+}
+
+func (c tracedConn) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, span := c.start(ctx, query)
+	defer span.End()
+
+	err := c.dbConn.GetContext(ctx, dest, query, args...)
+	rows := 1
+	if err == sql.ErrNoRows {
+		rows = 0
+	} else if err != nil {
+		recordError(span, err)
+		return parseError(err)
+	}
+
+	span.SetAttributes(attribute.Int("db.rows_returned", rows))
+	return err
+}
+
+func (c tracedConn) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, span := c.start(ctx, query)
+	defer span.End()
+
+	err := c.dbConn.SelectContext(ctx, dest, query, args...)
+	if err != nil {
+		recordError(span, err)
+		return parseError(err)
+	}
+
+	span.SetAttributes(attribute.Int("db.rows_returned", reflect.Indirect(reflect.ValueOf(dest)).Len()))
+	return nil
+}
+
+func (c tracedConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := c.start(ctx, query)
+	defer span.End()
+
+	res, err := c.dbConn.ExecContext(ctx, query, args...)
+	if err != nil {
+		recordError(span, err)
+		return nil, parseError(err)
+	}
+
+	if rows, err := res.RowsAffected(); err == nil {
+		span.SetAttributes(attribute.Int64("db.rows_affected", rows))
+	}
+	return res, nil
+}
+
+// start starts the span of a statement, named after its operation and table
+func (c tracedConn) start(ctx context.Context, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, statementName(query),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", c.system),
+			attribute.String("db.statement", query),
+		),
+	)
+}
+
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// statementName returns a short name for a SQL statement, such as "UPDATE users"
+func statementName(query string) string {
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return "SQL"
+	}
+
+	op := strings.ToUpper(words[0])
+	target := ""
+	switch op {
+	case "SELECT", "DELETE":
+		target = wordAfter(words, "FROM")
+	case "INSERT":
+		target = wordAfter(words, "INTO")
+	case "UPDATE":
+		if len(words) > 1 {
+			target = words[1]
+		}
+	}
+
+	if target == "" {
+		return op
+	}
+	return op + " " + target
+}
+
+// wordAfter returns the word following the first occurrence of keyword, ignoring case
+func wordAfter(words []string, keyword string) string {
+	for i, word := range words[:len(words)-1] {
+		if strings.EqualFold(word, keyword) {
+			return strings.Trim(words[i+1], "()")
+		}
+	}
+	return ""
+}
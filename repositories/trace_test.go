@@ -0,0 +1,90 @@
+package repositories
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/s1moe2/gosrv/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	spanRecorder     *tracetest.SpanRecorder
+	spanRecorderOnce sync.Once
+)
+
+// recordSpans installs a global tracer provider recording spans, once for all tests
+// since tracers obtained before only follow the first provider installed
+func recordSpans() *tracetest.SpanRecorder {
+	spanRecorderOnce.Do(func() {
+		spanRecorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+	})
+	return spanRecorder
+}
+
+func spanAttrs(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestTracedConn(t *testing.T) {
+	recorder := recordSpans()
+	ctx := context.Background()
+	repo := seedUserRepo(t, "ann", "bob")
+	start := len(recorder.Ended())
+
+	if _, err := repo.Update(ctx, &models.User{ID: "1", Name: "anne", Email: "ann@gosrv.com"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := repo.GetAll(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended()[start:] {
+		spans[span.Name()] = span
+	}
+
+	update, ok := spans["UPDATE users"]
+	if !ok {
+		t.Fatalf("expected an UPDATE users span, got %v", spans)
+	}
+	attrs := spanAttrs(update)
+	if attrs["db.system"].AsString() != "sqlite" || attrs["db.rows_affected"].AsInt64() != 1 {
+		t.Fatalf("unexpected UPDATE users attributes %v", attrs)
+	}
+	if attrs["db.statement"].AsString() == "" {
+		t.Fatal("expected the statement to be recorded")
+	}
+
+	selectAll, ok := spans["SELECT users"]
+	if !ok || spanAttrs(selectAll)["db.rows_returned"].AsInt64() != 2 {
+		t.Fatalf("expected a SELECT users span returning 2 rows, got %v", spans)
+	}
+}
+
+func TestStatementName(t *testing.T) {
+	cases := map[string]string{
+		"SELECT COUNT(*) FROM users WHERE id = ?":         "SELECT users",
+		"INSERT INTO users (name, email) VALUES (?, ?)":   "INSERT users",
+		"\n\t\tUPDATE users SET name = ?":                 "UPDATE users",
+		"DELETE FROM users WHERE id = $1":                 "DELETE users",
+		"SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)": "SELECT users",
+		"SAVEPOINT sp_1": "SAVEPOINT",
+		"":               "SQL",
+	}
+
+	for query, name := range cases {
+		if got := statementName(query); got != name {
+			t.Fatalf("expected %q to be named %q, got %q", query, name, got)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// dbConn is the set of sqlx methods shared by *sqlx.DB and *sqlx.Tx that repositories use
+type dbConn interface {
+	sqlx.ExtContext
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type txKey struct{}
+
+// txState is the transaction carried in a context, along with how deeply nested it is
+type txState struct {
+	tx    *sqlx.Tx
+	depth int
+}
+
+// TxManager implements models.TxRunner with sqlx transactions.
+// Nested calls run inside savepoints of the outermost transaction.
+type TxManager struct {
+	db *sqlx.DB
+}
+
+// NewTxManager returns a configured TxManager object
+func NewTxManager(db *sqlx.DB) *TxManager {
+	return &TxManager{
+		db: db,
+	}
+}
+
+// RunInTx runs fn inside a transaction, or inside a savepoint if ctx already carries one.
+// The transaction (or savepoint) is rolled back if fn returns an error or panics,
+// in which case the panic is propagated once the rollback is done.
+func (m *TxManager) RunInTx(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if state, ok := ctx.Value(txKey{}).(*txState); ok {
+		return runInSavepoint(ctx, state, fn)
+	}
+
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(parseError(err), "failed to begin transaction")
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				err = errors.Wrapf(err, "failed to roll back transaction (%v)", rbErr)
+			}
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			err = errors.Wrap(parseError(err), "failed to commit transaction")
+		}
+	}()
+
+	return fn(context.WithValue(ctx, txKey{}, &txState{tx: tx}))
+}
+
+// runInSavepoint runs fn inside a savepoint of the transaction carried by ctx
+func runInSavepoint(ctx context.Context, parent *txState, fn func(ctx context.Context) error) (err error) {
+	state := &txState{tx: parent.tx, depth: parent.depth + 1}
+	name := fmt.Sprintf("sp_%d", state.depth)
+
+	if _, err = state.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return errors.Wrap(err, "failed to create savepoint")
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = rollbackSavepoint(ctx, state.tx, name)
+			panic(p)
+		}
+
+		if err != nil {
+			if rbErr := rollbackSavepoint(ctx, state.tx, name); rbErr != nil {
+				err = errors.Wrapf(err, "failed to roll back savepoint (%v)", rbErr)
+			}
+			return
+		}
+
+		if _, err = state.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+			err = errors.Wrap(err, "failed to release savepoint")
+		}
+	}()
+
+	return fn(context.WithValue(ctx, txKey{}, state))
+}
+
+// rollbackSavepoint rolls back to a savepoint then releases it, as rolling back to a
+// savepoint keeps it on the stack of the transaction
+func rollbackSavepoint(ctx context.Context, tx *sqlx.Tx, name string) error {
+	if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	return err
+}
+
+// connFromContext returns the transaction carried by ctx, or db if there is none
+func connFromContext(ctx context.Context, db *sqlx.DB) dbConn {
+	if state, ok := ctx.Value(txKey{}).(*txState); ok {
+		return state.tx
+	}
+	return db
+}
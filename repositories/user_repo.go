@@ -3,38 +3,233 @@ package repositories
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"github.com/jmoiron/sqlx"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/s1moe2/gosrv/models"
 )
 
-// UserRepo implements models.UserRepository
+// UserRepo implements models.UserRepository on PostgreSQL or SQLite.
+// Its methods run inside the transaction carried by their context, if any, see TxManager.
 type UserRepo struct {
-	db *sqlx.DB
+	db      *sqlx.DB
+	dialect dialect
+	tx      *TxManager
 }
 
 // NewUserRepo returns a configured UserRepo object
 func NewUserRepo(db *sqlx.DB) *UserRepo {
 	return &UserRepo{
-		db: db,
+		db:      db,
+		dialect: dialectFor(db.DriverName()),
+		tx:      NewTxManager(db),
 	}
 }
 
-// GetAll fetches all users, returns an empty slice if no user exists
+// conn returns the connection statements must run on for a given context
+func (r *UserRepo) conn(ctx context.Context) dbConn {
+	return tracedConn{dbConn: connFromContext(ctx, r.db), system: r.dialect.system}
+}
+
+// rebind turns the '?' placeholders of a statement into the driver's bind type
+func (r *UserRepo) rebind(stmt string) string {
+	return r.db.Rebind(stmt)
+}
+
+// GetAll fetches all users that aren't deleted, returns an empty slice if no user exists
 func (r *UserRepo) GetAll(ctx context.Context) ([]*models.User, error) {
 	users := []*models.User{}
-	err := r.db.SelectContext(ctx, &users, "SELECT id, name, email FROM users")
+	err := r.conn(ctx).SelectContext(ctx, &users, "SELECT id, name, email, version, deleted_at FROM users WHERE deleted_at IS NULL")
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// userColumns maps the fields a user listing can be sorted or filtered by to their column
+var userColumns = map[string]string{
+	"id":    "id",
+	"name":  "name",
+	"email": "email",
+}
+
+// List fetches a page of users matching the given options, along with the total
+// number of users matching the filters regardless of pagination
+func (r *UserRepo) List(ctx context.Context, opts models.ListOptions) ([]*models.User, int, error) {
+	where, args, err := r.buildUserWhere(opts.Filters, opts.IncludeDeleted)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	err = r.conn(ctx).GetContext(ctx, &total, r.rebind("SELECT COUNT(*) FROM users"+where), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	orderBy, err := buildUserOrderBy(opts.Sort)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	stmt := "SELECT id, name, email, version, deleted_at FROM users" + where + orderBy
+	if opts.Limit > 0 {
+		args = append(args, opts.Limit)
+		stmt += " LIMIT ?"
+	}
+	if opts.Offset > 0 {
+		if opts.Limit <= 0 {
+			stmt += " LIMIT " + r.dialect.noLimit
+		}
+		args = append(args, opts.Offset)
+		stmt += " OFFSET ?"
+	}
+
+	users := []*models.User{}
+	err = r.conn(ctx).SelectContext(ctx, &users, r.rebind(stmt), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+// Seek fetches a page of users following (or preceding) a keyset, ordered by
+// the sort column and then by primary key, so that pages stay consistent
+// while users are written concurrently
+func (r *UserRepo) Seek(ctx context.Context, opts models.SeekOptions) ([]*models.User, error) {
+	where, args, err := r.buildUserWhere(opts.Filters, opts.IncludeDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	column, ok := userColumns[opts.Sort.Field]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort field %q", opts.Sort.Field)
+	}
+
+	// scanning backward walks the index in the opposite direction and the
+	// results are reversed afterwards to keep them in sort order
+	desc := opts.Sort.Desc != opts.Backward
+	cmp, dir := ">", "ASC"
+	if desc {
+		cmp, dir = "<", "DESC"
+	}
+
+	if opts.Keyset != nil {
+		var cond string
+		if column == "id" {
+			args = append(args, opts.Keyset.ID)
+			cond = "id " + cmp + " ?"
+		} else {
+			args = append(args, opts.Keyset.Value, opts.Keyset.ID)
+			cond = fmt.Sprintf("(%s, id) %s (?, ?)", column, cmp)
+		}
+
+		if where == "" {
+			where = " WHERE " + cond
+		} else {
+			where += " AND " + cond
+		}
+	}
+
+	orderBy := fmt.Sprintf(" ORDER BY %s %s", column, dir)
+	if column != "id" {
+		orderBy += ", id " + dir
+	}
+
+	args = append(args, opts.Limit)
+	stmt := "SELECT id, name, email, version, deleted_at FROM users" + where + orderBy + " LIMIT ?"
+
+	users := []*models.User{}
+	err = r.conn(ctx).SelectContext(ctx, &users, r.rebind(stmt), args...)
 	if err != nil {
 		return nil, err
 	}
 
+	if opts.Backward {
+		for i, j := 0, len(users)-1; i < j; i, j = i+1, j-1 {
+			users[i], users[j] = users[j], users[i]
+		}
+	}
+
 	return users, nil
 }
 
-// FindByID finds a user by ID, returns nil if not found
-func (r *UserRepo) FindByID(ctx context.Context, ID string) (*models.User, error) {
+// buildUserWhere builds the WHERE clause and its arguments from a list of filters,
+// leaving deleted users out unless includeDeleted is set
+func (r *UserRepo) buildUserWhere(filters []models.Filter, includeDeleted bool) (string, []interface{}, error) {
+	var conds []string
+	var args []interface{}
+
+	if !includeDeleted {
+		conds = append(conds, "deleted_at IS NULL")
+	}
+
+	for _, f := range filters {
+		column, ok := userColumns[f.Field]
+		if !ok {
+			return "", nil, fmt.Errorf("unknown filter field %q", f.Field)
+		}
+
+		switch f.Op {
+		case models.FilterEq:
+			args = append(args, f.Value)
+			conds = append(conds, column+" = ?")
+		case models.FilterContains:
+			args = append(args, "%"+escapeLike(f.Value)+"%")
+			conds = append(conds, fmt.Sprintf(`%s %s ? ESCAPE '\'`, column, r.dialect.like))
+		default:
+			return "", nil, fmt.Errorf("unknown filter operator %q", f.Op)
+		}
+	}
+
+	if len(conds) == 0 {
+		return "", nil, nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args, nil
+}
+
+// buildUserOrderBy builds the ORDER BY clause from a list of sort fields,
+// always ending with the primary key so that pages are stable
+func buildUserOrderBy(sort []models.SortField) (string, error) {
+	var terms []string
+	sortedByID := false
+
+	for _, s := range sort {
+		column, ok := userColumns[s.Field]
+		if !ok {
+			return "", fmt.Errorf("unknown sort field %q", s.Field)
+		}
+
+		dir := "ASC"
+		if s.Desc {
+			dir = "DESC"
+		}
+		terms = append(terms, column+" "+dir)
+		sortedByID = sortedByID || column == "id"
+	}
+
+	if !sortedByID {
+		terms = append(terms, "id ASC")
+	}
+	return " ORDER BY " + strings.Join(terms, ", "), nil
+}
+
+// escapeLike escapes the LIKE pattern metacharacters of a user provided value
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// FindByID finds a user by ID, returns nil if not found or deleted, unless includeDeleted is set
+func (r *UserRepo) FindByID(ctx context.Context, ID string, includeDeleted bool) (*models.User, error) {
 	user := &models.User{}
-	err := r.db.GetContext(ctx, user, "SELECT id, name, email FROM users WHERE id = $1", ID)
+	stmt := "SELECT id, name, email, version, deleted_at FROM users WHERE id = ? AND (? OR deleted_at IS NULL)"
+	err := r.conn(ctx).GetContext(ctx, user, r.rebind(stmt), ID, includeDeleted)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -44,11 +239,11 @@ func (r *UserRepo) FindByID(ctx context.Context, ID string) (*models.User, error
 	return user, nil
 }
 
-// FindByEmail finds a user by email, returns nil if not found
+// FindByEmail finds a user that isn't deleted by email, returns nil if not found
 func (r *UserRepo) FindByEmail(ctx context.Context, email string) (*models.User, error) {
 	user := &models.User{}
-	stmt := "SELECT id, name, email FROM users WHERE email = $1"
-	err := r.db.GetContext(ctx, user, stmt, email)
+	stmt := "SELECT id, name, email, version, deleted_at FROM users WHERE email = ? AND deleted_at IS NULL"
+	err := r.conn(ctx).GetContext(ctx, user, r.rebind(stmt), email)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -59,37 +254,133 @@ func (r *UserRepo) FindByEmail(ctx context.Context, email string) (*models.User,
 }
 
 // Create creates a new user, returning the full model
-func (r *UserRepo) Create(user *models.User) (*models.User, error) {
-	stmt := "INSERT INTO users (name, email) VALUES ($1, $2) RETURNING id"
-	row := r.db.QueryRow(stmt, user.Name, user.Email)
-	err := row.Scan(&user.ID)
+func (r *UserRepo) Create(ctx context.Context, user *models.User) (*models.User, error) {
+	if r.dialect.returning {
+		stmt := "INSERT INTO users (name, email) VALUES (?, ?) RETURNING id, version"
+		err := r.conn(ctx).GetContext(ctx, user, r.rebind(stmt), user.Name, user.Email)
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	stmt := "INSERT INTO users (name, email, version) VALUES (?, ?, 1)"
+	res, err := r.conn(ctx).ExecContext(ctx, r.rebind(stmt), user.Name, user.Email)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	user.ID = strconv.FormatInt(id, 10)
+	user.Version = 1
+	return user, nil
+}
+
+// Update updates a user, returning the updated model with its new version or nil if no rows were affected.
+// When user.Version is not zero, the update only happens if it still is the current version,
+// otherwise a StaleVersionError is returned.
+func (r *UserRepo) Update(ctx context.Context, user *models.User) (*models.User, error) {
+	stmt := `UPDATE users SET name = ?, email = ?, version = version + 1
+		WHERE id = ? AND deleted_at IS NULL AND (? = 0 OR version = ?)`
+	args := []interface{}{user.Name, user.Email, user.ID, user.Version, user.Version}
+
+	if r.dialect.returning {
+		err := r.conn(ctx).GetContext(ctx, &user.Version, r.rebind(stmt+" RETURNING version"), args...)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, r.checkVersion(ctx, user.ID, user.Version)
+			}
+			return nil, err
+		}
+		return user, nil
+	}
+
+	// without RETURNING, the new version is read back in the same transaction
+	var updated bool
+	err := r.tx.RunInTx(ctx, func(ctx context.Context) error {
+		res, err := r.conn(ctx).ExecContext(ctx, r.rebind(stmt), args...)
+		if err != nil {
+			return err
+		}
+		if rows, err := res.RowsAffected(); err != nil || rows == 0 {
+			return err
+		}
+
+		updated = true
+		return r.conn(ctx).GetContext(ctx, &user.Version, r.rebind("SELECT version FROM users WHERE id = ?"), user.ID)
+	})
 	if err != nil {
 		return nil, err
 	}
+	if !updated {
+		return nil, r.checkVersion(ctx, user.ID, user.Version)
+	}
 	return user, nil
 }
 
-// Update updates a user, returning the updated model or nil if no rows were affected
-func (r *UserRepo) Update(user *models.User) (*models.User, error) {
-	stmt := "UPDATE users SET name = $1, email = $2 WHERE id = $3"
-	res, err := r.db.Exec(stmt, user.Name, user.Email, user.ID)
+// Delete soft deletes a user, only returns error if action fails.
+// When version is not zero, the user is only deleted if it still is the current version,
+// otherwise a StaleVersionError is returned.
+func (r *UserRepo) Delete(ctx context.Context, ID string, version int64) (bool, error) {
+	stmt := `UPDATE users SET deleted_at = ?, version = version + 1
+		WHERE id = ? AND deleted_at IS NULL AND (? = 0 OR version = ?)`
+	res, err := r.conn(ctx).ExecContext(ctx, r.rebind(stmt), time.Now().UTC(), ID, version, version)
 	if err != nil {
-		return nil, parseError(err)
+		return false, err
 	}
 	rows, err := res.RowsAffected()
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 	if rows == 0 {
-		return nil, nil
+		return false, r.checkVersion(ctx, ID, version)
+	}
+	return true, nil
+}
+
+// Restore restores a deleted user, returning the restored model or nil if there is no such deleted user.
+// A ConflictError is returned if the email of the user has been taken since it was deleted.
+func (r *UserRepo) Restore(ctx context.Context, ID string) (*models.User, error) {
+	stmt := `UPDATE users SET deleted_at = NULL, version = version + 1
+		WHERE id = ? AND deleted_at IS NOT NULL`
+
+	if r.dialect.returning {
+		user := &models.User{}
+		err := r.conn(ctx).GetContext(ctx, user, r.rebind(stmt+" RETURNING id, name, email, version, deleted_at"), ID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return user, nil
+	}
+
+	// without RETURNING, the restored user is read back in the same transaction
+	var user *models.User
+	err := r.tx.RunInTx(ctx, func(ctx context.Context) error {
+		res, err := r.conn(ctx).ExecContext(ctx, r.rebind(stmt), ID)
+		if err != nil {
+			return err
+		}
+		if rows, err := res.RowsAffected(); err != nil || rows == 0 {
+			return err
+		}
+
+		user, err = r.FindByID(ctx, ID, false)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 	return user, nil
 }
 
-// Delete deletes a user, only returns error if action fails
-func (r *UserRepo) Delete(ID string) (bool, error) {
-	stmt := "DELETE FROM users WHERE id = $1 RETURNING id"
-	res, err := r.db.Exec(stmt, ID)
+// Purge permanently deletes a user, whether it is soft deleted or not, only returns error if action fails
+func (r *UserRepo) Purge(ctx context.Context, ID string) (bool, error) {
+	res, err := r.conn(ctx).ExecContext(ctx, r.rebind("DELETE FROM users WHERE id = ?"), ID)
 	if err != nil {
 		return false, err
 	}
@@ -99,3 +390,22 @@ func (r *UserRepo) Delete(ID string) (bool, error) {
 	}
 	return rows > 0, nil
 }
+
+// checkVersion tells apart a conditional write that affected no rows because the user
+// doesn't exist, returning no error, from one that expected a stale version
+func (r *UserRepo) checkVersion(ctx context.Context, ID string, version int64) error {
+	if version == 0 {
+		return nil
+	}
+
+	var exists bool
+	stmt := "SELECT EXISTS(SELECT 1 FROM users WHERE id = ? AND deleted_at IS NULL)"
+	err := r.conn(ctx).GetContext(ctx, &exists, r.rebind(stmt), ID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return newStaleVersionError(version)
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package repositories
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/s1moe2/gosrv/models"
+)
+
+// openSqliteDB opens an in-memory SQLite database with all migrations applied
+func openSqliteDB(t *testing.T) *sqlx.DB {
+	db, err := sqlx.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	files, err := filepath.Glob("../migrations/sqlite/*.up.sql")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("failed to find migrations: %v", err)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		stmt, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read migration %s: %v", file, err)
+		}
+		if _, err := db.Exec(string(stmt)); err != nil {
+			t.Fatalf("failed to apply migration %s: %v", file, err)
+		}
+	}
+	return db
+}
+
+func seedUserRepo(t *testing.T, names ...string) *UserRepo {
+	repo := NewUserRepo(openSqliteDB(t))
+	for _, name := range names {
+		_, err := repo.Create(context.Background(), &models.User{Name: name, Email: name + "@gosrv.com"})
+		if err != nil {
+			t.Fatalf("failed to seed user %s: %v", name, err)
+		}
+	}
+	return repo
+}
+
+func TestUserRepo_Sqlite_Create(t *testing.T) {
+	ctx := context.Background()
+	repo := seedUserRepo(t, "ann")
+
+	user, err := repo.Create(ctx, &models.User{Name: "bob", Email: "bob@gosrv.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.ID != "2" || user.Version != 1 {
+		t.Fatalf("expected ID 2 at version 1, got %+v", user)
+	}
+
+	_, err = repo.Create(ctx, &models.User{Name: "other ann", Email: "ann@gosrv.com"})
+	conflict, ok := err.(*ConflictError)
+	if !ok {
+		t.Fatalf("expected a ConflictError, got %v", err)
+	}
+	if conflict.Message != "[email] already exists with this value" {
+		t.Fatalf("unexpected conflict message %q", conflict.Message)
+	}
+}
+
+func TestUserRepo_Sqlite_Update(t *testing.T) {
+	ctx := context.Background()
+	repo := seedUserRepo(t, "ann", "bob")
+
+	user, err := repo.Update(ctx, &models.User{ID: "1", Name: "anne", Email: "ann@gosrv.com", Version: 1})
+	if err != nil || user.Version != 2 {
+		t.Fatalf("expected version 2, got %+v (%v)", user, err)
+	}
+
+	_, err = repo.Update(ctx, &models.User{ID: "1", Name: "annie", Email: "ann@gosrv.com", Version: 1})
+	if _, ok := err.(*StaleVersionError); !ok {
+		t.Fatalf("expected a StaleVersionError, got %v", err)
+	}
+
+	_, err = repo.Update(ctx, &models.User{ID: "2", Name: "bob", Email: "ann@gosrv.com"})
+	if _, ok := err.(*ConflictError); !ok {
+		t.Fatalf("expected a ConflictError, got %v", err)
+	}
+
+	user, err = repo.Update(ctx, &models.User{ID: "3", Name: "cid", Email: "cid@gosrv.com"})
+	if user != nil || err != nil {
+		t.Fatalf("expected no user and no error, got %+v (%v)", user, err)
+	}
+}
+
+func TestUserRepo_Sqlite_DeleteRestorePurge(t *testing.T) {
+	ctx := context.Background()
+	repo := seedUserRepo(t, "ann")
+
+	if deleted, err := repo.Delete(ctx, "1", 1); !deleted || err != nil {
+		t.Fatalf("expected the user to be deleted, got %v (%v)", deleted, err)
+	}
+	if user, _ := repo.FindByID(ctx, "1", false); user != nil {
+		t.Fatalf("expected the deleted user to be hidden, got %+v", user)
+	}
+	if user, _ := repo.FindByID(ctx, "1", true); user == nil || user.DeletedAt == nil {
+		t.Fatalf("expected the deleted user when included, got %+v", user)
+	}
+
+	if _, err := repo.Create(ctx, &models.User{Name: "new ann", Email: "ann@gosrv.com"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := repo.Restore(ctx, "1"); err == nil {
+		t.Fatal("expected a ConflictError restoring a user whose email was taken")
+	}
+
+	if purged, _ := repo.Purge(ctx, "2"); !purged {
+		t.Fatal("expected the user to be purged")
+	}
+	user, err := repo.Restore(ctx, "1")
+	if err != nil || user == nil || user.DeletedAt != nil || user.Version != 3 {
+		t.Fatalf("expected the user to be restored at version 3, got %+v (%v)", user, err)
+	}
+}
+
+func TestUserRepo_Sqlite_ListSeek(t *testing.T) {
+	ctx := context.Background()
+	repo := seedUserRepo(t, "bob", "ann", "cid", "dan")
+	_, _ = repo.Delete(ctx, "4", 0)
+
+	users, total, err := repo.List(ctx, models.ListOptions{
+		Offset:  1,
+		Sort:    []models.SortField{{Field: "name", Desc: true}},
+		Filters: []models.Filter{{Field: "email", Op: models.FilterContains, Value: "GOSRV"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if total != 3 || !reflect.DeepEqual(userIDs(users), []string{"1", "2"}) {
+		t.Fatalf("expected users [1 2] of 3, got %v of %d", userIDs(users), total)
+	}
+
+	_, total, _ = repo.List(ctx, models.ListOptions{Filters: []models.Filter{{Field: "name", Op: models.FilterContains, Value: "_"}}})
+	if total != 0 {
+		t.Fatalf("expected LIKE metacharacters to be escaped, got %d users", total)
+	}
+
+	byName := models.SortField{Field: "name"}
+	next, err := repo.Seek(ctx, models.SeekOptions{Limit: 2, Sort: byName, Keyset: &models.Keyset{Value: "ann", ID: "2"}})
+	if err != nil || !reflect.DeepEqual(userIDs(next), []string{"1", "3"}) {
+		t.Fatalf("expected next page [1 3], got %v (%v)", userIDs(next), err)
+	}
+
+	prev, err := repo.Seek(ctx, models.SeekOptions{Limit: 2, Sort: byName, Keyset: &models.Keyset{Value: "cid", ID: "3"}, Backward: true})
+	if err != nil || !reflect.DeepEqual(userIDs(prev), []string{"2", "1"}) {
+		t.Fatalf("expected prev page [2 1], got %v (%v)", userIDs(prev), err)
+	}
+}
+
+func TestTxManager_Sqlite(t *testing.T) {
+	ctx := context.Background()
+
+	// a savepoint can only be released once, so releasing it again tells whether it was
+	released := func(ctx context.Context, name string) bool {
+		_, err := connFromContext(ctx, nil).ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+		return err != nil
+	}
+	names := func(repo *UserRepo) []string {
+		users, err := repo.GetAll(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var names []string
+		for _, user := range users {
+			names = append(names, user.Name)
+		}
+		return names
+	}
+
+	t.Run("expect nested transactions to run in savepoints, released once done", func(t *testing.T) {
+		repo := seedUserRepo(t)
+		errNested := errors.New("nested failure")
+
+		err := repo.tx.RunInTx(ctx, func(ctx context.Context) error {
+			if _, err := repo.Create(ctx, &models.User{Name: "ann", Email: "ann@gosrv.com"}); err != nil {
+				return err
+			}
+
+			// a failing nested transaction only rolls back to its savepoint
+			err := repo.tx.RunInTx(ctx, func(ctx context.Context) error {
+				if _, err := repo.Create(ctx, &models.User{Name: "bob", Email: "bob@gosrv.com"}); err != nil {
+					return err
+				}
+				return errNested
+			})
+			if !errors.Is(err, errNested) {
+				t.Fatalf("expected the nested error, got %v", err)
+			}
+			if !released(ctx, "sp_1") {
+				t.Fatal("expected the rolled back savepoint to be released")
+			}
+
+			err = repo.tx.RunInTx(ctx, func(ctx context.Context) error {
+				_, err := repo.Create(ctx, &models.User{Name: "carl", Email: "carl@gosrv.com"})
+				return err
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !released(ctx, "sp_1") {
+				t.Fatal("expected the savepoint to be released")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got := names(repo); !reflect.DeepEqual(got, []string{"ann", "carl"}) {
+			t.Fatalf("expected only the successful writes to be committed, got %v", got)
+		}
+	})
+
+	t.Run("expect a panic to roll back the transaction and be propagated", func(t *testing.T) {
+		repo := seedUserRepo(t)
+
+		for _, nested := range []bool{false, true} {
+			func() {
+				defer func() {
+					if p := recover(); p != "boom" {
+						t.Fatalf("expected the panic to be propagated, got %v", p)
+					}
+				}()
+
+				_ = repo.tx.RunInTx(ctx, func(ctx context.Context) error {
+					if _, err := repo.Create(ctx, &models.User{Name: "ann", Email: "ann@gosrv.com"}); err != nil {
+						return err
+					}
+					if !nested {
+						panic("boom")
+					}
+					return repo.tx.RunInTx(ctx, func(ctx context.Context) error {
+						if _, err := repo.Create(ctx, &models.User{Name: "bob", Email: "bob@gosrv.com"}); err != nil {
+							return err
+						}
+						panic("boom")
+					})
+				})
+				t.Fatal("expected a panic")
+			}()
+
+			if got := names(repo); len(got) != 0 {
+				t.Fatalf("expected nothing to be committed, got %v", got)
+			}
+		}
+	})
+}
+
+func TestUserRepo_Sqlite_CancelledContext(t *testing.T) {
+	repo := seedUserRepo(t, "ann")
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	calls := map[string]func(ctx context.Context) error{
+		"create": func(ctx context.Context) error {
+			_, err := repo.Create(ctx, &models.User{Name: "bob", Email: "bob@gosrv.com"})
+			return err
+		},
+		"update": func(ctx context.Context) error {
+			_, err := repo.Update(ctx, &models.User{ID: "1", Name: "anna", Email: "ann@gosrv.com"})
+			return err
+		},
+		"find": func(ctx context.Context) error {
+			_, err := repo.FindByID(ctx, "1", false)
+			return err
+		},
+		"list": func(ctx context.Context) error {
+			_, _, err := repo.List(ctx, models.ListOptions{Limit: 10})
+			return err
+		},
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			if err := call(cancelled); !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected context.Canceled, got %v", err)
+			}
+
+			err := call(expired)
+			var timeout *TimeoutError
+			if !errors.As(err, &timeout) || !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("expected a TimeoutError wrapping context.DeadlineExceeded, got %T: %v", err, err)
+			}
+		})
+	}
+
+	user, err := repo.FindByID(context.Background(), "1", false)
+	if err != nil || user.Name != "ann" || user.Version != 1 {
+		t.Fatalf("expected the user to be untouched, got %+v (%v)", user, err)
+	}
+	if other, err := repo.FindByEmail(context.Background(), "bob@gosrv.com"); err != nil || other != nil {
+		t.Fatalf("expected no user to be created, got %+v (%v)", other, err)
+	}
+}
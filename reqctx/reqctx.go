@@ -0,0 +1,103 @@
+// Package reqctx carries request scoped values, such as the request ID, the feature
+// flags enabled or the identity of the client, in a context
+package reqctx
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+)
+
+// RequestIDHeader is the header a request ID is accepted from and echoed on
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the length of request IDs accepted from clients
+const maxRequestIDLength = 128
+
+type key int
+
+const (
+	requestIDKey key = iota
+	featuresKey
+	clientIdentityKey
+)
+
+// ClientIdentity is the identity of a client authenticated by a verified TLS certificate
+type ClientIdentity struct {
+	// CommonName and Organization come from the subject of the certificate
+	CommonName   string
+	Organization []string
+	// DNSNames, EmailAddresses and URIs are its subject alternative names
+	DNSNames       []string
+	EmailAddresses []string
+	URIs           []string
+	// SerialNumber is the serial number of the certificate, in decimal
+	SerialNumber string
+}
+
+// WithRequestID returns a copy of ctx carrying the given request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID carried by ctx, or an empty string if there is none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithFeatures returns a copy of ctx carrying the names of the feature flags enabled
+func WithFeatures(ctx context.Context, features []string) context.Context {
+	return context.WithValue(ctx, featuresKey, features)
+}
+
+// FeatureEnabled reports whether the feature flag with the given name is enabled in ctx
+func FeatureEnabled(ctx context.Context, name string) bool {
+	features, _ := ctx.Value(featuresKey).([]string)
+	for _, f := range features {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WithClientIdentity returns a copy of ctx carrying the identity of the client
+func WithClientIdentity(ctx context.Context, id ClientIdentity) context.Context {
+	return context.WithValue(ctx, clientIdentityKey, id)
+}
+
+// ClientIdentityFrom returns the identity of the client carried by ctx, and whether there
+// is one, which is only the case for clients authenticated with a certificate
+func ClientIdentityFrom(ctx context.Context) (ClientIdentity, bool) {
+	id, ok := ctx.Value(clientIdentityKey).(ClientIdentity)
+	return id, ok
+}
+
+// NewRequestID generates a random request ID, formatted as a version 4 UUID
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate request ID: %s", err))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// ValidRequestID reports whether a request ID sent by a client can be used as is: it must be
+// short and made of characters that can't break log lines or headers
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
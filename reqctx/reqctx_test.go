@@ -0,0 +1,63 @@
+package reqctx
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	if id := RequestID(context.Background()); id != "" {
+		t.Fatalf("expected no request ID, got %q", id)
+	}
+
+	ctx := WithRequestID(context.Background(), "abc")
+	if id := RequestID(ctx); id != "abc" {
+		t.Fatalf("expected request ID abc, got %q", id)
+	}
+}
+
+func TestFeatureEnabled(t *testing.T) {
+	if FeatureEnabled(context.Background(), "beta") {
+		t.Fatal("expected no feature enabled")
+	}
+
+	ctx := WithFeatures(context.Background(), []string{"alpha", "beta"})
+	if !FeatureEnabled(ctx, "beta") {
+		t.Fatal("expected feature beta to be enabled")
+	}
+	if FeatureEnabled(ctx, "gamma") {
+		t.Fatal("expected feature gamma not to be enabled")
+	}
+}
+
+func TestNewRequestID(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	id := NewRequestID()
+	if !uuid.MatchString(id) {
+		t.Fatalf("expected a version 4 UUID, got %q", id)
+	}
+	if id == NewRequestID() {
+		t.Fatal("expected request IDs to be unique")
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	cases := map[string]bool{
+		"":                             false,
+		"f47ac10b-58cc-4372-a567-0e02": true,
+		"trace:abc/def+1=":             true,
+		"has space":                    false,
+		"new\nline":                    false,
+		`quote"`:                       false,
+		strings.Repeat("a", 129):       false,
+	}
+
+	for id, valid := range cases {
+		if ValidRequestID(id) != valid {
+			t.Fatalf("expected ValidRequestID(%q) to be %v", id, valid)
+		}
+	}
+}
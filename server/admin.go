@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/s1moe2/gosrv/buildinfo"
+)
+
+// newAdminRouter returns the router of the admin listener, serving profiling, metrics and
+// debug endpoints which must not be reachable by the public
+func newAdminRouter(m *metrics, started time.Time) *mux.Router {
+	router := mux.NewRouter()
+	router.Handle("/metrics", m.handler()).Methods(http.MethodGet)
+
+	router.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	router.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	router.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	router.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	// the index also serves the named profiles, such as /debug/pprof/heap
+	router.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+
+	router.HandleFunc("/debug/version", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminJSON(w, buildinfo.Get())
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/debug/runtime", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminJSON(w, newRuntimeInfo(started))
+	}).Methods(http.MethodGet)
+
+	return router
+}
+
+// runtimeInfo is a snapshot of the state of the Go runtime
+type runtimeInfo struct {
+	GoVersion  string         `json:"go_version"`
+	OS         string         `json:"os"`
+	Arch       string         `json:"arch"`
+	NumCPU     int            `json:"num_cpu"`
+	GoMaxProcs int            `json:"gomaxprocs"`
+	Goroutines int            `json:"goroutines"`
+	StartedAt  time.Time      `json:"started_at"`
+	Uptime     string         `json:"uptime"`
+	Memory     memoryInfo     `json:"memory"`
+	Build      buildinfo.Info `json:"build"`
+}
+
+type memoryInfo struct {
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+	HeapObjects    uint64 `json:"heap_objects"`
+	SysBytes       uint64 `json:"sys_bytes"`
+	NumGC          uint32 `json:"num_gc"`
+	LastGC         string `json:"last_gc,omitempty"`
+}
+
+func newRuntimeInfo(started time.Time) runtimeInfo {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	info := runtimeInfo{
+		GoVersion:  runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		NumCPU:     runtime.NumCPU(),
+		GoMaxProcs: runtime.GOMAXPROCS(0),
+		Goroutines: runtime.NumGoroutine(),
+		StartedAt:  started.UTC(),
+		Uptime:     time.Since(started).Round(time.Second).String(),
+		Memory: memoryInfo{
+			HeapAllocBytes: ms.HeapAlloc,
+			HeapObjects:    ms.HeapObjects,
+			SysBytes:       ms.Sys,
+			NumGC:          ms.NumGC,
+		},
+		Build: buildinfo.Get(),
+	}
+	if ms.LastGC > 0 {
+		info.Memory.LastGC = time.Unix(0, int64(ms.LastGC)).UTC().Format(time.RFC3339)
+	}
+	return info
+}
+
+func writeAdminJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(v)
+}
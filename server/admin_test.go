@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/s1moe2/gosrv/health"
+)
+
+func TestAdminRouter(t *testing.T) {
+	router := newAdminRouter(newMetrics(), time.Now().Add(-time.Minute))
+
+	for _, path := range []string{"/metrics", "/debug/pprof/", "/debug/pprof/heap", "/debug/pprof/cmdline"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", path, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/runtime", nil))
+	var info runtimeInfo
+	if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+		t.Fatalf("failed to decode runtime info: %v", err)
+	}
+	if info.Goroutines == 0 || info.Uptime != "1m0s" || info.Build.Version != "dev" {
+		t.Fatalf("unexpected runtime info %+v", info)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/version", nil))
+	if !strings.Contains(w.Body.String(), `"version":"dev"`) {
+		t.Fatalf("unexpected version %s", w.Body.String())
+	}
+}
+
+func TestAPIServer_StopsBothListeners(t *testing.T) {
+	s := &apiServer{health: health.New(time.Second), httpServer: &http.Server{}}
+	s.setupAdmin("", http.NotFoundHandler())
+
+	served := make(chan error, 2)
+	for _, srv := range []*http.Server{s.httpServer, s.adminServer} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %v", err)
+		}
+		go func(srv *http.Server) { served <- srv.Serve(l) }(srv)
+	}
+
+	if err := s.stop(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-served; err != http.ErrServerClosed {
+			t.Fatalf("expected the listeners to be closed, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/s1moe2/gosrv/config"
+	"github.com/s1moe2/gosrv/health"
+)
+
+// newHealth returns the readiness checks of the storage: the database must answer
+// pings and have no pending migrations
+func newHealth(store *storage, conf *config.AppConfig) (*health.Health, error) {
+	checks := health.New(conf.Server.ReadinessTimeout)
+	if store.db == nil {
+		return checks, nil
+	}
+
+	migrator, err := newMigrator(store.db, conf.Database.Driver)
+	if err != nil {
+		return nil, err
+	}
+
+	checks.Register("database", health.CheckerFunc(store.db.PingContext))
+	checks.Register("migrations", health.CheckerFunc(func(ctx context.Context) error {
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		if pending > 0 {
+			return fmt.Errorf("%d pending migrations", pending)
+		}
+		return nil
+	}))
+	return checks, nil
+}
@@ -5,7 +5,8 @@ import (
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/s1moe2/gosrv/config"
-	"log"
+	"github.com/s1moe2/gosrv/health"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -15,14 +16,37 @@ import (
 
 type apiServer struct {
 	httpServer *http.Server
+	// health is told when the shutdown sequence begins, to stop reporting the server as ready
+	health        *health.Health
+	shutdownDelay time.Duration
+	// live is reloaded on SIGHUP
+	live *liveConfig
+	// certs, when serving HTTPS, is checked for new certificates every certsInterval
+	certs         *certReloader
+	certsInterval time.Duration
+	// adminServer, when set, serves the admin endpoints on their own address
+	adminServer *http.Server
 }
 
-func newServer(serverConfig config.ServerConfig, router *mux.Router) *apiServer {
+// newServer returns the server of router. The observers, such as the access log, wrap
+// every request from outside the timeout handler, in the order given, so that they see
+// the responses the clients get, including the ones of unmatched routes and timeouts.
+func newServer(live *liveConfig, router *mux.Router, checks *health.Health, observers ...mux.MiddlewareFunc) *apiServer {
+	serverConfig := live.config().Server
+	// request IDs are set outside the timeout handler, so that timed out responses carry them too,
+	// and preflight requests are answered before reaching the router
+	handler := live.featuresMiddleware(live.timeoutMiddleware(router))
+	for i := len(observers) - 1; i >= 0; i-- {
+		handler = observers[i](handler)
+	}
 	return &apiServer{
+		health:        checks,
+		shutdownDelay: serverConfig.ShutdownDelay,
+		live:          live,
 		httpServer: &http.Server{
 			Addr: serverConfig.Address,
 			//ErrorLog:     log.New(logrus.New().Writer(), "", 0),
-			Handler:      http.TimeoutHandler(router, serverConfig.HandlerTimeout, "request timeout"),
+			Handler:      requestIDMiddleware(clientIdentityMiddleware(live.corsMiddleware(handler))),
 			ReadTimeout:  serverConfig.ReadTimeout,
 			WriteTimeout: serverConfig.WriteTimeout,
 			IdleTimeout:  serverConfig.IdleTimeout,
@@ -30,43 +54,125 @@ func newServer(serverConfig config.ServerConfig, router *mux.Router) *apiServer
 	}
 }
 
+// setupTLS makes the server speak HTTPS when a certificate is configured
+func (s *apiServer) setupTLS(tlsConfig config.TLSConfig) error {
+	tc, certs, err := newTLSConfig(tlsConfig)
+	if err != nil {
+		return err
+	}
+	s.httpServer.TLSConfig = tc
+	s.certs = certs
+	s.certsInterval = tlsConfig.ReloadInterval
+	return nil
+}
+
+// setupAdmin adds the admin listener, serving handler on its own address. Profiles can
+// take longer than the API write timeout, so responses are not bounded.
+func (s *apiServer) setupAdmin(address string, handler http.Handler) {
+	s.adminServer = &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: s.httpServer.ReadTimeout,
+		IdleTimeout:       s.httpServer.IdleTimeout,
+	}
+}
+
 func (s *apiServer) start() error {
-	//channel to listen for errors coming from the listener.
-	serverErrors := make(chan error, 1)
+	//channel to listen for errors coming from the listeners.
+	serverErrors := make(chan error, 2)
+
+	if s.adminServer != nil {
+		go func() {
+			slog.Info("admin listening", "address", s.adminServer.Addr)
+			if err := s.adminServer.ListenAndServe(); err != http.ErrServerClosed {
+				serverErrors <- fmt.Errorf("admin: %w", err)
+			}
+		}()
+	}
 
 	go func() {
-		log.Printf("main : API listening on %s", s.httpServer.Addr)
-		serverErrors <- s.httpServer.ListenAndServe()
+		if s.httpServer.TLSConfig == nil {
+			slog.Info("API listening", "address", s.httpServer.Addr)
+			serverErrors <- s.httpServer.ListenAndServe()
+			return
+		}
+		slog.Info("API listening", "address", s.httpServer.Addr, "tls", true, "client_auth", s.httpServer.TLSConfig.ClientCAs != nil)
+		// the certificate is served by the TLS configuration, so no files are given
+		serverErrors <- s.httpServer.ListenAndServeTLS("", "")
 	}()
 
+	if s.certs != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go s.certs.watch(ctx, s.certsInterval)
+	}
+
 	// channel to listen for an interrupt or terminate signal from the OS.
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
-	// blocking run and waiting for shutdown.
-	select {
-	case err := <-serverErrors:
-		return fmt.Errorf("error: starting server: %s", err)
+	// channel to listen for a hangup signal, asking to reload the configuration.
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
 
-	case <-shutdown:
-		log.Println("main : Start shutdown")
+	// blocking run and waiting for shutdown, reloading the configuration meanwhile.
+	for {
+		select {
+		case err := <-serverErrors:
+			return fmt.Errorf("error: starting server: %s", err)
 
-		// give outstanding requests a deadline for completion.
-		const timeout = 5 * time.Second
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
+		case <-reload:
+			slog.Info("reloading configuration")
+			s.live.reload()
 
-		// asking listener to shutdown
-		err := s.httpServer.Shutdown(ctx)
-		if err != nil {
-			log.Printf("main : Graceful shutdown did not complete in %v : %v", timeout, err)
-			err = s.httpServer.Close()
+		case <-shutdown:
+			return s.stop()
 		}
+	}
+}
 
-		if err != nil {
-			return fmt.Errorf("main : could not stop server gracefully : %v", err)
-		}
+// stop takes the server out of rotation then gracefully shuts it down
+func (s *apiServer) stop() error {
+	slog.Info("starting shutdown")
+	s.health.Shutdown()
+	if s.shutdownDelay > 0 {
+		time.Sleep(s.shutdownDelay)
+	}
+
+	// give outstanding requests a deadline for completion.
+	const timeout = 5 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// asking listeners to shutdown, together so that they share the deadline
+	adminErr := make(chan error, 1)
+	go func() {
+		adminErr <- shutdownServer(ctx, s.adminServer, timeout)
+	}()
+
+	err := shutdownServer(ctx, s.httpServer, timeout)
+	if aerr := <-adminErr; err == nil && aerr != nil {
+		err = fmt.Errorf("admin: %w", aerr)
+	}
+
+	if err != nil {
+		return fmt.Errorf("main : could not stop server gracefully : %v", err)
 	}
 
 	return nil
 }
+
+// shutdownServer gracefully shuts a server down, closing it if ctx is done first
+func shutdownServer(ctx context.Context, srv *http.Server, timeout time.Duration) error {
+	if srv == nil {
+		return nil
+	}
+
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		slog.Warn("graceful shutdown did not complete", "address", srv.Addr, "timeout", timeout, "error", err)
+		err = srv.Close()
+	}
+	return err
+}
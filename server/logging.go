@@ -0,0 +1,247 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/s1moe2/gosrv/config"
+	"github.com/s1moe2/gosrv/reqctx"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newLogger returns the application logger for the configured level and format. The level
+// is set on the given LevelVar, if any, so that it can be changed while the logger is in use.
+func newLogger(logConfig config.LogConfig, out io.Writer, level *slog.LevelVar) (*slog.Logger, error) {
+	if level == nil {
+		level = new(slog.LevelVar)
+	}
+	if err := setLogLevel(level, logConfig.Level); err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch logConfig.Format {
+	case "json":
+		return slog.New(contextHandler{slog.NewJSONHandler(out, opts)}), nil
+	case "logfmt":
+		return slog.New(contextHandler{slog.NewTextHandler(out, opts)}), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", logConfig.Format)
+	}
+}
+
+// setLogLevel sets a LevelVar to a level named in the configuration
+func setLogLevel(level *slog.LevelVar, name string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return fmt.Errorf("invalid log level %q", name)
+	}
+	level.Set(l)
+	return nil
+}
+
+// contextHandler adds the request scoped values of the context to every record it handles
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := reqctx.RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		record.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// accessEntry is what gets logged about a served request
+type accessEntry struct {
+	start    time.Time
+	duration time.Duration
+	method   string
+	uri      string
+	proto    string
+	route    string
+	remoteIP string
+	referer  string
+	agent    string
+	status   int
+	bytes    int64
+}
+
+// accessLogger writes one entry per served request, either through the application
+// logger or, for the combined format, as Apache Combined Log Format lines
+type accessLogger struct {
+	logger *slog.Logger
+	// combined is set when entries are written to out in the Apache Combined Log Format
+	combined bool
+	out      io.Writer
+	mu       sync.Mutex
+}
+
+// newAccessLogger returns an accessLogger for the configured access log format
+func newAccessLogger(logConfig config.LogConfig, out io.Writer, level *slog.LevelVar) (*accessLogger, error) {
+	if logConfig.AccessFormat == "combined" {
+		return &accessLogger{combined: true, out: out}, nil
+	}
+
+	logger, err := newLogger(config.LogConfig{Level: logConfig.Level, Format: logConfig.AccessFormat}, out, level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access log format %q", logConfig.AccessFormat)
+	}
+	return &accessLogger{logger: logger}, nil
+}
+
+// log writes an access log entry, at the error level for server errors
+func (al *accessLogger) log(ctx context.Context, e accessEntry) {
+	if al.combined {
+		al.mu.Lock()
+		defer al.mu.Unlock()
+		fmt.Fprintln(al.out, e.combined())
+		return
+	}
+
+	level := slog.LevelInfo
+	if e.status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+
+	al.logger.LogAttrs(ctx, level, "request",
+		slog.String("method", e.method),
+		slog.String("uri", e.uri),
+		slog.String("route", e.route),
+		slog.Int("status", e.status),
+		slog.Int64("bytes", e.bytes),
+		slog.Duration("duration", e.duration),
+		slog.String("remote_ip", e.remoteIP),
+		slog.String("referer", e.referer),
+		slog.String("user_agent", e.agent),
+	)
+}
+
+// combined formats the entry as an Apache Combined Log Format line
+func (e accessEntry) combined() string {
+	size := "-"
+	if e.bytes > 0 {
+		size = strconv.FormatInt(e.bytes, 10)
+	}
+
+	return fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %s %s %s`,
+		orDash(e.remoteIP),
+		e.start.Format("02/Jan/2006:15:04:05 -0700"),
+		e.method, e.uri, e.proto,
+		e.status, size,
+		strconv.Quote(orDash(e.referer)),
+		strconv.Quote(orDash(e.agent)),
+	)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// middleware returns a middleware logging every request it serves
+func (al *accessLogger) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = withMatchedRoute(r)
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		al.log(r.Context(), accessEntry{
+			start:    start,
+			duration: time.Since(start),
+			method:   r.Method,
+			uri:      r.RequestURI,
+			proto:    r.Proto,
+			route:    routeTemplate(r),
+			remoteIP: remoteIP(r),
+			referer:  r.Referer(),
+			agent:    r.UserAgent(),
+			status:   rec.status,
+			bytes:    rec.bytes,
+		})
+	})
+}
+
+// routeTemplate returns the template of the route a request matched, such as
+// /users/{id}, so that requests to the same route are logged alike. Outside the router,
+// it is the template recorded by routeMiddleware, once the request has been served.
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	if mr, ok := r.Context().Value(matchedRouteKey{}).(*matchedRoute); ok {
+		if tpl, ok := mr.template.Load().(string); ok {
+			return tpl
+		}
+	}
+	return ""
+}
+
+type matchedRouteKey struct{}
+
+// matchedRoute carries the template of the route a request matched from the router out
+// to the middlewares wrapping it, which only know the request they passed on. It may be
+// set by a handler still running after a timeout, hence the atomic.
+type matchedRoute struct {
+	template atomic.Value
+}
+
+// withMatchedRoute returns the request carrying a matchedRoute, adding one if it has none
+func withMatchedRoute(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(matchedRouteKey{}).(*matchedRoute); ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), matchedRouteKey{}, &matchedRoute{}))
+}
+
+// recordRoute records the template of the route a request matched, for routeTemplate
+func recordRoute(r *http.Request, template string) {
+	if mr, ok := r.Context().Value(matchedRouteKey{}).(*matchedRoute); ok {
+		mr.template.Store(template)
+	}
+}
+
+// routeMiddleware records the template of the route matched by the router it is used on
+func routeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tpl := routeTemplate(r); tpl != "" {
+			recordRoute(r, tpl)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// remoteIP returns the IP address of the client, without its port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return strings.TrimSpace(r.RemoteAddr)
+	}
+	return host
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/s1moe2/gosrv/config"
+	"github.com/s1moe2/gosrv/reqctx"
+)
+
+func serveLogged(t *testing.T, logConfig config.LogConfig, req *http.Request) string {
+	var out bytes.Buffer
+	al, err := newAccessLogger(logConfig, &out, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	router := mux.NewRouter()
+	router.Use(al.middleware)
+	router.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	return out.String()
+}
+
+func TestAccessLogger_JSON(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/users/42?x=1", nil)
+	req.RemoteAddr = "10.0.0.1:5678"
+
+	line := serveLogged(t, config.LogConfig{Level: "info", AccessFormat: "json"}, req)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatalf("expected a JSON entry, got %q", line)
+	}
+	expected := map[string]interface{}{
+		"level":     "INFO",
+		"uri":       "/users/42?x=1",
+		"route":     "/users/{id}",
+		"status":    float64(201),
+		"bytes":     float64(5),
+		"remote_ip": "10.0.0.1",
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Fatalf("expected %s to be %v, got %v", key, value, entry[key])
+		}
+	}
+	if _, ok := entry["duration"]; !ok {
+		t.Fatal("expected the duration to be logged")
+	}
+}
+
+func TestAccessLogger_Logfmt(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+
+	line := serveLogged(t, config.LogConfig{Level: "info", AccessFormat: "logfmt"}, req)
+	if !strings.Contains(line, "route=/users/{id}") || !strings.Contains(line, "status=201") {
+		t.Fatalf("unexpected logfmt entry %q", line)
+	}
+}
+
+func TestAccessLogger_Combined(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+	req.RemoteAddr = "10.0.0.1:5678"
+	req.Header.Set("User-Agent", "curl/8.0")
+
+	line := serveLogged(t, config.LogConfig{Level: "info", AccessFormat: "combined"}, req)
+	if !strings.HasPrefix(line, "10.0.0.1 - - [") ||
+		!strings.HasSuffix(line, `] "GET /users/42 HTTP/1.1" 201 5 "-" "curl/8.0"`+"\n") {
+		t.Fatalf("unexpected combined entry %q", line)
+	}
+}
+
+func TestNewAccessLogger_InvalidFormat(t *testing.T) {
+	if _, err := newAccessLogger(config.LogConfig{Level: "info", AccessFormat: "xml"}, nil, nil); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+	if _, err := newLogger(config.LogConfig{Level: "loud", Format: "json"}, nil, nil); err == nil {
+		t.Fatal("expected an error for an unknown level")
+	}
+}
+
+type hijackableRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (h *hijackableRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h.hijacked = true
+	return nil, nil, nil
+}
+
+func TestStatusRecorder(t *testing.T) {
+	w := &hijackableRecorder{ResponseRecorder: httptest.NewRecorder()}
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+	rec.Write([]byte("abc"))
+	rec.WriteHeader(http.StatusTeapot)
+	rec.Flush()
+	if rec.status != http.StatusOK || rec.bytes != 3 || !w.Flushed {
+		t.Fatalf("expected an implicit 200 with 3 bytes flushed, got %d with %d", rec.status, rec.bytes)
+	}
+
+	if _, _, err := rec.Hijack(); err != nil || !w.hijacked {
+		t.Fatalf("expected the connection to be hijacked, got %v", err)
+	}
+
+	plain := &statusRecorder{ResponseWriter: httptest.NewRecorder()}
+	if _, _, err := plain.Hijack(); err == nil {
+		t.Fatal("expected an error hijacking a writer that doesn't support it")
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := newLogger(config.LogConfig{Level: "info", Format: "json"}, &out, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var seen string
+	handler := requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = reqctx.RequestID(r.Context())
+		logger.InfoContext(r.Context(), "handled")
+	}))
+
+	t.Run("expect a valid client request ID to be kept", func(t *testing.T) {
+		out.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(reqctx.RequestIDHeader, "client-id")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if seen != "client-id" || w.Header().Get(reqctx.RequestIDHeader) != "client-id" {
+			t.Fatalf("expected client-id in context and response, got %q and %q", seen, w.Header().Get(reqctx.RequestIDHeader))
+		}
+
+		var entry map[string]interface{}
+		if err := json.Unmarshal(out.Bytes(), &entry); err != nil || entry["request_id"] != "client-id" {
+			t.Fatalf("expected the log line to carry the request ID, got %q", out.String())
+		}
+	})
+
+	t.Run("expect an invalid client request ID to be replaced", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(reqctx.RequestIDHeader, "bad id\n")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if seen == "" || seen == "bad id\n" || w.Header().Get(reqctx.RequestIDHeader) != seen {
+			t.Fatalf("expected a generated request ID, got %q", seen)
+		}
+	})
+}
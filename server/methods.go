@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/s1moe2/gosrv/problem"
+)
+
+// routeMethods are the methods routes may be registered for, in the order they are listed
+// in Allow headers
+var routeMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// methodNotAllowedHandler returns the handler of requests matching a route of router by
+// path but not by method. It answers HEAD for routes serving GET, as GET without a body,
+// and OPTIONS with the methods allowed, leaving the others to fail with 405. Every
+// answer lists the methods allowed in the Allow header.
+func methodNotAllowedHandler(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed := allowedMethods(router, r)
+
+		switch {
+		case r.Method == http.MethodHead && contains(allowed, http.MethodGet):
+			get := r.Clone(r.Context())
+			get.Method = http.MethodGet
+			router.ServeHTTP(headWriter{w}, get)
+		case r.Method == http.MethodOptions:
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			problem.New(http.StatusMethodNotAllowed, "the resource doesn't support the request method").Write(w, r)
+		}
+	})
+}
+
+// allowedMethods returns the methods the routes of router matching the path of r are
+// registered for, along with the methods answered for them: HEAD and OPTIONS. As the
+// router runs no middleware for r, it records the route template of r itself.
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	var allowed []string
+	for _, method := range routeMethods {
+		req := r.Clone(r.Context())
+		req.Method = method
+
+		var match mux.RouteMatch
+		if router.Match(req, &match) && match.MatchErr == nil {
+			if len(allowed) == 0 {
+				if tpl, err := match.Route.GetPathTemplate(); err == nil {
+					recordRoute(r, tpl)
+				}
+			}
+			allowed = append(allowed, method)
+			if method == http.MethodGet {
+				allowed = append(allowed, http.MethodHead)
+			}
+		}
+	}
+	return append(allowed, http.MethodOptions)
+}
+
+// headWriter discards the body of a response to a HEAD request
+type headWriter struct {
+	http.ResponseWriter
+}
+
+func (w headWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/s1moe2/gosrv/problem"
+)
+
+func TestMethodNotAllowedHandler(t *testing.T) {
+	router := mux.NewRouter()
+	router.NotFoundHandler = problem.Handler(http.StatusNotFound, "no resource matches the request path")
+	router.MethodNotAllowedHandler = methodNotAllowedHandler(router)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Method", r.Method)
+		w.Write([]byte("ok"))
+	})
+	ur := router.PathPrefix("/users").Subrouter()
+	ur.Methods(http.MethodGet).Path("/").Handler(ok)
+	ur.Methods(http.MethodPost).Path("/").Handler(ok)
+	ur.Methods(http.MethodGet).Path("/{id}").Handler(ok)
+	ur.Methods(http.MethodPut).Path("/{id}").Handler(ok)
+	ur.Methods(http.MethodDelete).Path("/{id}").Handler(ok)
+	ur.Methods(http.MethodPost).Path("/{id}/purge").Handler(ok)
+
+	cases := []struct {
+		method, path string
+		status       int
+		allow        string
+		contentType  string
+		body         string
+	}{
+		{http.MethodGet, "/users/1", http.StatusOK, "", "", "ok"},
+		{http.MethodPatch, "/users/", http.StatusMethodNotAllowed, "GET, HEAD, POST, OPTIONS", problem.ContentType, ""},
+		{http.MethodGet, "/users/1/purge", http.StatusMethodNotAllowed, "POST, OPTIONS", problem.ContentType, ""},
+		{http.MethodOptions, "/users/1", http.StatusNoContent, "GET, HEAD, PUT, DELETE, OPTIONS", "", ""},
+		{http.MethodHead, "/users/1", http.StatusOK, "", "", ""},
+		{http.MethodHead, "/users/1/purge", http.StatusMethodNotAllowed, "POST, OPTIONS", problem.ContentType, ""},
+		{http.MethodGet, "/nope", http.StatusNotFound, "", problem.ContentType, ""},
+		{http.MethodOptions, "/nope", http.StatusNotFound, "", problem.ContentType, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.method+" "+c.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(c.method, c.path, nil))
+
+			if w.Code != c.status || w.Header().Get("Allow") != c.allow {
+				t.Fatalf("expected %d with Allow %q, got %d with Allow %q", c.status, c.allow, w.Code, w.Header().Get("Allow"))
+			}
+			if c.contentType != "" && w.Header().Get("Content-Type") != c.contentType {
+				t.Fatalf("expected content type %s, got %s", c.contentType, w.Header().Get("Content-Type"))
+			}
+			if c.body != "" && w.Body.String() != c.body {
+				t.Fatalf("expected body %q, got %q", c.body, w.Body.String())
+			}
+			if c.method == http.MethodHead && c.status == http.StatusOK && (w.Body.Len() != 0 || w.Header().Get("X-Method") != http.MethodGet) {
+				t.Fatalf("expected the GET headers without body, got %v %q", w.Header(), w.Body.String())
+			}
+		})
+	}
+}
+
+func TestMethodNotAllowedHandler_Observed(t *testing.T) {
+	m := newMetrics()
+	router := mux.NewRouter()
+	router.MethodNotAllowedHandler = methodNotAllowedHandler(router)
+	router.Use(routeMiddleware)
+	ur := router.PathPrefix("/users").Subrouter()
+	ur.Methods(http.MethodGet).Path("/{id}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	handler := m.middleware(router)
+	for _, method := range []string{http.MethodHead, http.MethodOptions, http.MethodPatch} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/users/1", nil))
+	}
+
+	body := scrape(t, m.handler())
+	for _, line := range []string{
+		`gosrv_http_requests_total{method="HEAD",route="/users/{id}",status="200"} 1`,
+		`gosrv_http_requests_total{method="OPTIONS",route="/users/{id}",status="204"} 1`,
+		`gosrv_http_requests_total{method="PATCH",route="/users/{id}",status="405"} 1`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("expected metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, `method="GET"`) {
+		t.Fatalf("expected HEAD requests to be recorded as HEAD, got:\n%s", body)
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the Prometheus collectors exposed on the metrics endpoint. They are kept
+// in their own registry rather than the global one, so that each server has its own.
+type metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	// panics counts the panics recovered from handlers, by route template
+	panics *prometheus.CounterVec
+	// reloads counts configuration reloads by result, success or failure
+	reloads *prometheus.CounterVec
+	// reloadSuccessful is 1 when the last reload succeeded, and lastReload the time of the last success
+	reloadSuccessful prometheus.Gauge
+	lastReload       prometheus.Gauge
+}
+
+// newMetrics returns metrics collecting HTTP requests along with Go runtime and process metrics
+func newMetrics() *metrics {
+	labels := []string{"route", "method", "status"}
+
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gosrv",
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests served.",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "gosrv",
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to serve HTTP requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, labels),
+		panics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gosrv",
+			Name:      "http_panics_total",
+			Help:      "Number of panics recovered from HTTP handlers.",
+		}, []string{"route"}),
+		reloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gosrv",
+			Name:      "config_reloads_total",
+			Help:      "Number of configuration reloads, by result.",
+		}, []string{"result"}),
+		reloadSuccessful: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "gosrv",
+			Name:      "config_last_reload_successful",
+			Help:      "Whether the last configuration reload succeeded.",
+		}),
+		lastReload: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "gosrv",
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      "Time of the last successful configuration reload, or of startup.",
+		}),
+	}
+	m.reloadSuccessful.Set(1)
+	m.lastReload.SetToCurrentTime()
+
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		m.panics,
+		m.reloads,
+		m.reloadSuccessful,
+		m.lastReload,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// observeReload records the outcome of a configuration reload
+func (m *metrics) observeReload(err error) {
+	if err != nil {
+		m.reloads.WithLabelValues("failure").Inc()
+		m.reloadSuccessful.Set(0)
+		return
+	}
+	m.reloads.WithLabelValues("success").Inc()
+	m.reloadSuccessful.Set(1)
+	m.lastReload.SetToCurrentTime()
+}
+
+// registerDB adds the connection pool statistics of a database to the metrics
+func (m *metrics) registerDB(db *sql.DB, name string) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// handler returns the handler serving the metrics in the Prometheus text format
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// middleware returns a middleware counting and timing the requests it serves, labelled
+// by route template rather than path so that the number of series stays bounded
+func (m *metrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = withMatchedRoute(r)
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		labels := prometheus.Labels{
+			"route":  routeTemplate(r),
+			"method": r.Method,
+			"status": strconv.Itoa(rec.status),
+		}
+		m.requests.With(labels).Inc()
+		m.duration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
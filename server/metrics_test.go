@@ -0,0 +1,101 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/s1moe2/gosrv/problem"
+	_ "modernc.org/sqlite"
+)
+
+func scrape(t *testing.T, router http.Handler) string {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 scraping metrics, got %d", w.Code)
+	}
+
+	body, err := ioutil.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal("failed to read metrics")
+	}
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	db, err := sqlx.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	m := newMetrics()
+	m.registerDB(db.DB, "sqlite")
+
+	router := mux.NewRouter()
+	router.Use(m.middleware)
+	router.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["id"] == "0" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	router.Handle("/metrics", m.handler())
+
+	for _, path := range []string{"/users/1", "/users/2", "/users/0"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	body := scrape(t, router)
+	expected := []string{
+		`gosrv_http_requests_total{method="GET",route="/users/{id}",status="200"} 2`,
+		`gosrv_http_requests_total{method="GET",route="/users/{id}",status="404"} 1`,
+		`gosrv_http_request_duration_seconds_count{method="GET",route="/users/{id}",status="200"} 2`,
+		`go_sql_open_connections{db_name="sqlite"}`,
+		`go_goroutines `,
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line) {
+			t.Fatalf("expected metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+}
+
+func TestNewServer_Observers(t *testing.T) {
+	live, m := newTestLiveConfig(t, nil)
+	conf := *live.config()
+	conf.Server.HandlerTimeout = 10 * time.Millisecond
+	live.current.Store(&conf)
+
+	router := mux.NewRouter()
+	router.NotFoundHandler = problem.Handler(http.StatusNotFound, "no resource matches the request path")
+	router.Use(routeMiddleware)
+	router.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handler := newServer(live, router, nil, m.middleware).httpServer.Handler
+	for _, path := range []string{"/users/1", "/nope", "/slow"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	metricsRouter := mux.NewRouter()
+	metricsRouter.Handle("/metrics", m.handler())
+	body := scrape(t, metricsRouter)
+	for _, line := range []string{
+		`gosrv_http_requests_total{method="GET",route="/users/{id}",status="200"} 1`,
+		`gosrv_http_requests_total{method="GET",route="",status="404"} 1`,
+		`gosrv_http_requests_total{method="GET",route="/slow",status="503"} 1`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("expected metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+}
@@ -1,24 +1,69 @@
 package server
 
 import (
-	"log"
+	"bufio"
+	"errors"
+	"net"
 	"net/http"
+
+	"github.com/s1moe2/gosrv/reqctx"
 )
 
+// requestIDMiddleware accepts the request ID sent by the client, or generates one,
+// carries it in the request context and echoes it on the response
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(reqctx.RequestIDHeader)
+		if !reqctx.ValidRequestID(id) {
+			id = reqctx.NewRequestID()
+		}
+
+		w.Header().Set(reqctx.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(reqctx.WithRequestID(r.Context(), id)))
+	})
+}
+
+// statusRecorder records the status code and the number of body bytes of a response
 type statusRecorder struct {
 	http.ResponseWriter
-	status int
+	status      int
+	bytes       int64
+	wroteHeader bool
 }
 
 func (rec *statusRecorder) WriteHeader(code int) {
-	rec.status = code
+	if !rec.wroteHeader {
+		rec.status = code
+		rec.wroteHeader = true
+	}
 	rec.ResponseWriter.WriteHeader(code)
 }
 
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		rec := statusRecorder{w, http.StatusOK}
-		next.ServeHTTP(&rec, r)
-		log.Println(r.Method, r.RequestURI, r.RemoteAddr, r.Referer(), r.UserAgent(), rec.status)
-	})
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher, so that streaming responses still work when recorded
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		rec.wroteHeader = true
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, so that connections can still be taken over when recorded
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.Hijacker: not supported by the response writer")
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap returns the recorded response writer, for http.ResponseController
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
 }
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/s1moe2/gosrv/config"
+	"github.com/s1moe2/gosrv/db"
+	"github.com/s1moe2/gosrv/migrations"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+const migrateUsage = `usage: gosrv migrate <command>
+
+commands:
+  up [N]       apply the next N pending migrations, all of them by default
+  down [N]     revert the last N applied migrations, one by default
+  status       list migrations and whether they are applied
+  goto V       migrate up or down to version V, 0 reverting everything
+  create NAME  create empty up and down files for a new migration`
+
+var errMigrateUsage = errors.New(migrateUsage)
+
+// Migrate runs the migrate subcommand, writing its output to out
+func Migrate(conf *config.AppConfig, args []string, out io.Writer) error {
+	logger, err := newLogger(conf.Log, os.Stderr, nil)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
+	if len(args) == 0 {
+		return errMigrateUsage
+	}
+
+	switch args[0] {
+	case "create":
+		if len(args) != 2 {
+			return errMigrateUsage
+		}
+		paths, err := db.CreateMigration(migrations.Dir(conf.Database.Driver), args[1])
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			fmt.Fprintln(out, path)
+		}
+		return nil
+
+	case "up", "down", "goto", "status":
+	default:
+		return errMigrateUsage
+	}
+
+	var n uint64
+	if len(args) > 2 || (args[0] == "goto" && len(args) != 2) || (args[0] == "status" && len(args) != 1) {
+		return errMigrateUsage
+	}
+	if len(args) == 2 {
+		var err error
+		if n, err = strconv.ParseUint(args[1], 10, 64); err != nil {
+			return fmt.Errorf("invalid number %q\n%s", args[1], migrateUsage)
+		}
+	}
+
+	dbConn, err := db.ConnectDB(conf.Database)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+
+	migrator, err := newMigrator(dbConn, conf.Database.Driver)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		return migrator.Up(ctx, int(n))
+	case "down":
+		return migrator.Down(ctx, int(n))
+	case "goto":
+		return migrator.Goto(ctx, n)
+	default:
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATUS")
+		for _, s := range statuses {
+			status := "pending"
+			if s.Applied {
+				status = "applied"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, status)
+		}
+		return w.Flush()
+	}
+}
+
+// newMigrator returns a migrator for the embedded migrations of a database driver
+func newMigrator(dbConn *sqlx.DB, driver string) (*db.Migrator, error) {
+	source, err := migrations.FS(driver)
+	if err != nil {
+		return nil, err
+	}
+	return db.NewMigrator(dbConn, source)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/s1moe2/gosrv/problem"
+	"golang.org/x/time/rate"
+)
+
+// clientIdleTime is how long the limiter of a client is kept once it stops sending requests
+const clientIdleTime = 3 * time.Minute
+
+// unlimitedPaths are the paths of the probes and the metrics, which orchestrators and
+// scrapers must reach however many requests clients send
+var unlimitedPaths = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+
+// rateLimiter limits the rate of requests of each client IP with a token bucket
+type rateLimiter struct {
+	mu        sync.Mutex
+	limit     rate.Limit
+	burst     int
+	clients   map[string]*client
+	lastSweep time.Time
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newRateLimiter returns a rateLimiter allowing rps requests per second with bursts of
+// up to burst requests, or any number of requests when rps is 0
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	rl := &rateLimiter{clients: map[string]*client{}}
+	rl.set(rps, burst)
+	return rl
+}
+
+// set changes the rate limit, including for the clients already seen
+func (rl *rateLimiter) set(rps float64, burst int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.limit, rl.burst = rate.Limit(rps), burst
+	if rps == 0 {
+		rl.clients = map[string]*client{}
+		return
+	}
+	for _, c := range rl.clients {
+		c.limiter.SetLimit(rl.limit)
+		c.limiter.SetBurst(rl.burst)
+	}
+}
+
+// allow reports whether a client may send a request now, or else how long it should wait
+func (rl *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.limit == 0 {
+		return true, 0
+	}
+	rl.sweep(now)
+
+	c, ok := rl.clients[key]
+	if !ok {
+		c = &client{limiter: rate.NewLimiter(rl.limit, rl.burst)}
+		rl.clients[key] = c
+	}
+	c.lastSeen = now
+
+	res := c.limiter.ReserveN(now, 1)
+	if !res.OK() {
+		return false, time.Second
+	}
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// sweep forgets the clients idle for long, at most once a minute
+func (rl *rateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < time.Minute {
+		return
+	}
+	rl.lastSweep = now
+	for key, c := range rl.clients {
+		if now.Sub(c.lastSeen) > clientIdleTime {
+			delete(rl.clients, key)
+		}
+	}
+}
+
+// middleware rejects with 429 Too Many Requests the requests of clients above the rate
+// limit, except the requests to unlimitedPaths, which it doesn't count either
+func (rl *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unlimitedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ok, wait := rl.allow(remoteIP(r), time.Now())
+		if ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		problem.New(http.StatusTooManyRequests, "the rate limit of the client was exceeded").Write(w, r)
+	})
+}
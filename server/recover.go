@@ -0,0 +1,55 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/s1moe2/gosrv/handlers"
+)
+
+// recoverMiddleware returns a middleware recovering from the panics of the handlers it
+// wraps: the panic is logged with its stack trace, counted, and the request fails with
+// the usual internal error, unless the response was already started, in which case it
+// is aborted. With repanic set, the panic is raised again once logged and counted.
+func recoverMiddleware(panics *prometheus.CounterVec, repanic bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				// ErrAbortHandler is how handlers abort a response on purpose, which the
+				// HTTP server handles silently
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+
+				route := routeTemplate(r)
+				slog.ErrorContext(r.Context(), "panic serving request",
+					"panic", fmt.Sprint(p),
+					"method", r.Method,
+					"uri", r.RequestURI,
+					"route", route,
+					"stack", string(debug.Stack()),
+				)
+				panics.WithLabelValues(route).Inc()
+
+				if repanic {
+					panic(p)
+				}
+				if rec.wroteHeader {
+					panic(http.ErrAbortHandler)
+				}
+				handlers.RespondInternalError(w, r)
+			}()
+
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/s1moe2/gosrv/config"
+	"github.com/s1moe2/gosrv/problem"
+	"github.com/s1moe2/gosrv/reqctx"
+)
+
+func newPanickingRouter(m *metrics, repanic bool) *mux.Router {
+	router := mux.NewRouter()
+	router.Use(recoverMiddleware(m.panics, repanic))
+	router.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["id"] == "started" {
+			w.Write([]byte("partial"))
+		}
+		panic("boom")
+	})
+	router.Handle("/metrics", m.handler())
+	return router
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	logger, err := newLogger(config.LogConfig{Level: "info", Format: "json"}, &logs, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func(l *slog.Logger) { slog.SetDefault(l) }(slog.Default())
+	slog.SetDefault(logger)
+
+	m := newMetrics()
+	router := newPanickingRouter(m, false)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	router.ServeHTTP(w, r.WithContext(reqctx.WithRequestID(r.Context(), "req-1")))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", w.Code)
+	}
+	var body problem.Problem
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	if body.Status != http.StatusInternalServerError || body.RequestID != "req-1" {
+		t.Fatalf("unexpected body %+v", body)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to decode log entry %q: %v", logs.String(), err)
+	}
+	if entry["panic"] != "boom" || entry["request_id"] != "req-1" || entry["route"] != "/users/{id}" ||
+		!strings.Contains(entry["stack"].(string), "recover_test.go") {
+		t.Fatalf("unexpected log entry %v", entry)
+	}
+
+	if !strings.Contains(scrape(t, router), `gosrv_http_panics_total{route="/users/{id}"} 1`) {
+		t.Fatal("expected the panic to be counted")
+	}
+}
+
+func TestRecoverMiddleware_AbortsStartedResponses(t *testing.T) {
+	router := newPanickingRouter(newMetrics(), false)
+
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Fatalf("expected the response to be aborted, got %v", p)
+		}
+	}()
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/started", nil))
+}
+
+func TestRecoverMiddleware_Repanic(t *testing.T) {
+	m := newMetrics()
+	router := newPanickingRouter(m, true)
+
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Fatalf("expected the panic to be raised again, got %v", p)
+			}
+		}()
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	}()
+
+	if !strings.Contains(scrape(t, router), `gosrv_http_panics_total{route="/users/{id}"} 1`) {
+		t.Fatal("expected the panic to be counted")
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/s1moe2/gosrv/config"
+	"github.com/s1moe2/gosrv/reqctx"
+)
+
+// liveConfig holds the configuration in effect while the server runs. A reload replaces it
+// as a whole, so that each request sees a consistent configuration, and only ever changes
+// the settings that can safely change without restarting.
+type liveConfig struct {
+	current atomic.Pointer[config.AppConfig]
+	// load reads the configuration again from the sources it was first read from
+	load     func() (*config.AppConfig, error)
+	logLevel *slog.LevelVar
+	limiter  *rateLimiter
+	metrics  *metrics
+}
+
+func newLiveConfig(conf *config.AppConfig, load func() (*config.AppConfig, error), logLevel *slog.LevelVar, m *metrics) *liveConfig {
+	lc := &liveConfig{
+		load:     load,
+		logLevel: logLevel,
+		limiter:  newRateLimiter(conf.Server.RateLimit, conf.Server.RateBurst),
+		metrics:  m,
+	}
+	lc.current.Store(conf)
+	return lc
+}
+
+// config returns the configuration in effect
+func (lc *liveConfig) config() *config.AppConfig {
+	return lc.current.Load()
+}
+
+// reload reads the configuration again and applies the settings that can change at runtime.
+// An invalid configuration is rejected as a whole, leaving the one in effect untouched.
+func (lc *liveConfig) reload() error {
+	err := lc.apply()
+	lc.metrics.observeReload(err)
+	if err != nil {
+		slog.Error("configuration reload failed, keeping the current configuration", "error", err)
+	}
+	return err
+}
+
+func (lc *liveConfig) apply() error {
+	next, err := lc.load()
+	if err != nil {
+		return err
+	}
+
+	conf, applied, ignored := config.Reloaded(lc.config(), next)
+	if err := setLogLevel(lc.logLevel, conf.Log.Level); err != nil {
+		return err
+	}
+	lc.limiter.set(conf.Server.RateLimit, conf.Server.RateBurst)
+	lc.current.Store(conf)
+
+	slog.Info("configuration reloaded", "applied", applied)
+	if len(ignored) > 0 {
+		slog.Warn("configuration changes ignored until restart", "settings", ignored)
+	}
+	return nil
+}
+
+// timeoutMiddleware bounds the time allowed to handle a request by the handler timeout in effect
+func (lc *liveConfig) timeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conf := lc.config().Server
+		timeoutHandler(next, conf.HandlerTimeout, conf.TimeoutStatus).ServeHTTP(w, r)
+	})
+}
+
+// featuresMiddleware carries the feature flags in effect in the request context
+func (lc *liveConfig) featuresMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(reqctx.WithFeatures(r.Context(), lc.config().Server.Features)))
+	})
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/s1moe2/gosrv/config"
+	"github.com/s1moe2/gosrv/reqctx"
+)
+
+func newTestLiveConfig(t *testing.T, load func() (*config.AppConfig, error)) (*liveConfig, *metrics) {
+	conf, _, err := config.Load(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	level := new(slog.LevelVar)
+	if err := setLogLevel(level, conf.Log.Level); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m := newMetrics()
+	return newLiveConfig(conf, load, level, m), m
+}
+
+func TestLiveConfig_Reload(t *testing.T) {
+	var loadErr error
+	live, m := newTestLiveConfig(t, func() (*config.AppConfig, error) {
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		conf, _, err := config.Load([]string{"--log-level", "debug", "--features", "beta", "--address", "localhost:5000"})
+		return conf, err
+	})
+
+	if err := live.reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if live.logLevel.Level() != slog.LevelDebug {
+		t.Fatalf("expected the log level to be debug, got %s", live.logLevel.Level())
+	}
+	if conf := live.config(); !strings.Contains(strings.Join(conf.Server.Features, ","), "beta") || conf.Server.Address != "localhost:4000" {
+		t.Fatalf("expected only the reloadable settings to change, got %+v", conf.Server)
+	}
+
+	loadErr = errors.New("invalid configuration")
+	before := live.config()
+	if err := live.reload(); err == nil {
+		t.Fatal("expected the reload to fail")
+	}
+	if live.config() != before {
+		t.Fatal("expected a failed reload to keep the current configuration")
+	}
+
+	router := mux.NewRouter()
+	router.Handle("/metrics", m.handler())
+	body := scrape(t, router)
+	for _, line := range []string{
+		`gosrv_config_reloads_total{result="success"} 1`,
+		`gosrv_config_reloads_total{result="failure"} 1`,
+		`gosrv_config_last_reload_successful 0`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("expected %q in metrics:\n%s", line, body)
+		}
+	}
+}
+
+func TestLiveConfig_ReloadInvalid(t *testing.T) {
+	live, _ := newTestLiveConfig(t, func() (*config.AppConfig, error) {
+		conf, _, err := config.Load([]string{"--timeout-status", "200"})
+		return conf, err
+	})
+
+	before := live.config()
+	if err := live.reload(); err == nil || !strings.Contains(err.Error(), `invalid value "200"`) {
+		t.Fatalf("expected the timeout status to be rejected, got %v", err)
+	}
+	if live.config() != before || live.config().Server.TimeoutStatus != http.StatusServiceUnavailable {
+		t.Fatal("expected a rejected reload to keep the current configuration")
+	}
+}
+
+func TestLiveConfig_Middlewares(t *testing.T) {
+	live, _ := newTestLiveConfig(t, nil)
+
+	// the handler may still run once timed out, hence the atomic
+	var enabled atomic.Bool
+	handler := live.featuresMiddleware(live.timeoutMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enabled.Store(reqctx.FeatureEnabled(r.Context(), "beta"))
+		time.Sleep(20 * time.Millisecond)
+	})))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || enabled.Load() {
+		t.Fatalf("expected 200 without the feature, got %d, enabled %v", w.Code, enabled.Load())
+	}
+
+	conf := *live.config()
+	conf.Server.HandlerTimeout = time.Millisecond
+	conf.Server.Features = []string{"beta"}
+	live.current.Store(&conf)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable || !enabled.Load() {
+		t.Fatalf("expected the new timeout and feature to apply, got %d, enabled %v", w.Code, enabled.Load())
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	live, _ := newTestLiveConfig(t, nil)
+	conf := *live.config()
+	conf.Server.CORSOrigins = []string{"https://app.example"}
+	live.current.Store(&conf)
+
+	handler := live.corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	cases := []struct {
+		name, method, origin string
+		preflight            bool
+		status               int
+		allowed              bool
+	}{
+		{"allowed", http.MethodGet, "https://app.example", false, http.StatusTeapot, true},
+		{"other origin", http.MethodGet, "https://evil.example", false, http.StatusTeapot, false},
+		{"preflight", http.MethodOptions, "https://app.example", true, http.StatusNoContent, true},
+		{"preflight other origin", http.MethodOptions, "https://evil.example", true, http.StatusTeapot, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(c.method, "/users/", nil)
+			r.Header.Set("Origin", c.origin)
+			if c.preflight {
+				r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != c.status {
+				t.Fatalf("expected status %d, got %d", c.status, w.Code)
+			}
+			if allowed := w.Header().Get("Access-Control-Allow-Origin") == c.origin; allowed != c.allowed {
+				t.Fatalf("expected origin allowed %v, got headers %v", c.allowed, w.Header())
+			}
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	rl := newRateLimiter(0, 1)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		if ok, _ := rl.allow("1.2.3.4", now); !ok {
+			t.Fatal("expected no limit when the rate is 0")
+		}
+	}
+
+	rl.set(1, 2)
+	for i := 0; i < 2; i++ {
+		if ok, _ := rl.allow("1.2.3.4", now); !ok {
+			t.Fatalf("expected request %d to be allowed within the burst", i+1)
+		}
+	}
+	if ok, wait := rl.allow("1.2.3.4", now); ok || wait != time.Second {
+		t.Fatalf("expected the request to be limited for 1s, got %v, %s", ok, wait)
+	}
+	if ok, _ := rl.allow("5.6.7.8", now); !ok {
+		t.Fatal("expected other clients not to be limited")
+	}
+	if ok, _ := rl.allow("1.2.3.4", now.Add(time.Second)); !ok {
+		t.Fatal("expected the request to be allowed once the bucket refills")
+	}
+
+	handler := rl.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/users/", nil)
+	r.RemoteAddr = "1.2.3.4:1234"
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After, got %d, headers %v", w.Code, w.Header())
+	}
+
+	for _, path := range []string{"/healthz", "/readyz", "/metrics"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = "1.2.3.4:1234"
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected %s not to be limited, got %d", path, w.Code)
+		}
+	}
+}
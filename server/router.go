@@ -2,13 +2,20 @@ package server
 
 import (
 	"github.com/gorilla/mux"
+	"github.com/s1moe2/gosrv/config"
 	"github.com/s1moe2/gosrv/handlers"
-	"github.com/s1moe2/gosrv/models"
 	"net/http"
 )
 
-func setupUsersRouter(router *mux.Router, repo models.UserRepository) {
-	h := handlers.NewUsersHandler(repo)
+func setupUsersRouter(router *mux.Router, store *storage, serverConfig config.ServerConfig) {
+	opts := []handlers.UsersHandlerOption{
+		handlers.WithCursorSecret([]byte(serverConfig.CursorSecret)),
+		handlers.WithMaxBodySize(int64(serverConfig.MaxBodySize)),
+	}
+	if store.tx != nil {
+		opts = append(opts, handlers.WithTxRunner(store.tx))
+	}
+	h := handlers.NewUsersHandler(store.userRepo, opts...)
 
 	ur := router.
 		PathPrefix("/users").
@@ -16,21 +23,33 @@ func setupUsersRouter(router *mux.Router, repo models.UserRepository) {
 
 	ur.Methods(http.MethodGet).
 		Path("/").
-		HandlerFunc(h.Get)
+		Handler(handlers.HandlerFunc(h.Get))
 
 	ur.Methods(http.MethodGet).
 		Path("/{id}").
-		HandlerFunc(h.GetByID)
+		Handler(handlers.HandlerFunc(h.GetByID))
 
 	ur.Methods(http.MethodPost).
 		Path("/").
-		HandlerFunc(h.Create)
+		Handler(handlers.HandlerFunc(h.Create))
 
 	ur.Methods(http.MethodPut).
 		Path("/{id}").
-		HandlerFunc(h.Update)
+		Handler(handlers.HandlerFunc(h.Update))
+
+	ur.Methods(http.MethodPatch).
+		Path("/{id}").
+		Handler(handlers.HandlerFunc(h.Patch))
 
 	ur.Methods(http.MethodDelete).
 		Path("/{id}").
-		HandlerFunc(h.Delete)
+		Handler(handlers.HandlerFunc(h.Delete))
+
+	ur.Methods(http.MethodPost).
+		Path("/{id}/restore").
+		Handler(handlers.HandlerFunc(h.Restore))
+
+	ur.Methods(http.MethodPost).
+		Path("/{id}/purge").
+		Handler(handlers.HandlerFunc(h.Purge))
 }
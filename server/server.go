@@ -1,30 +1,91 @@
 package server
 
 import (
+	"context"
 	"github.com/gorilla/mux"
 	"github.com/s1moe2/gosrv/config"
-	"github.com/s1moe2/gosrv/db"
-	"github.com/s1moe2/gosrv/repositories"
+	"github.com/s1moe2/gosrv/problem"
+	"log/slog"
 	"net/http"
+	"os"
+	"time"
 )
 
-// Run handles the API server configuration and setup before starting the HTTP server
-func Run() error {
-	conf := config.New()
+// Run handles the API server configuration and setup before starting the HTTP server.
+// load reads the configuration again when it is reloaded, on SIGHUP.
+func Run(conf *config.AppConfig, load func() (*config.AppConfig, error)) (err error) {
+	started := time.Now()
+	logLevel := new(slog.LevelVar)
+	logger, err := newLogger(conf.Log, os.Stderr, logLevel)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
+	accessLog, err := newAccessLogger(conf.Log, os.Stderr, logLevel)
+	if err != nil {
+		return err
+	}
+
+	shutdownTracing, err := setupTracing(context.Background(), conf.Tracing)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if shutdownErr := shutdownTracing(ctx); shutdownErr != nil {
+			slog.Error("failed to flush traces", "error", shutdownErr)
+		}
+	}()
 
-	dbConn, err := db.ConnectDB(conf.Database)
+	store, err := newStorage(conf.Database)
 	if err != nil {
 		return err
 	}
-	userRepo := repositories.NewUserRepo(dbConn)
+	defer func() {
+		if closeErr := store.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	metrics := newMetrics()
+	if store.db != nil {
+		metrics.registerDB(store.db.DB, conf.Database.Driver)
+	}
+
+	live := newLiveConfig(conf, load, logLevel, metrics)
 
 	router := mux.NewRouter()
-	router.Use(loggingMiddleware)
-	setupUsersRouter(router, userRepo)
+	router.NotFoundHandler = problem.Handler(http.StatusNotFound, "no resource matches the request path")
+	router.MethodNotAllowedHandler = methodNotAllowedHandler(router)
+	router.Use(
+		routeMiddleware,
+		recoverMiddleware(metrics.panics, conf.Server.Development),
+		live.limiter.middleware,
+	)
+	setupUsersRouter(router, store, conf.Server)
+	// metrics are only served publicly when there is no admin listener to serve them
+	if conf.Server.AdminAddress == "" {
+		router.Handle("/metrics", metrics.handler()).Methods(http.MethodGet)
+	}
+
+	checks, err := newHealth(store, conf)
+	if err != nil {
+		return err
+	}
+	router.Handle("/healthz", checks.LivenessHandler()).Methods(http.MethodGet)
+	router.Handle("/readyz", checks.ReadinessHandler()).Methods(http.MethodGet)
 
 	fs := http.FileServer(http.Dir("./swaggerui/"))
 	router.PathPrefix("/docs/").Handler(http.StripPrefix("/docs/", fs))
 
-	srv := newServer(conf.Server, router)
+	srv := newServer(live, router, checks, tracingMiddleware, accessLog.middleware, metrics.middleware)
+	if err := srv.setupTLS(conf.TLS); err != nil {
+		return err
+	}
+	if conf.Server.AdminAddress != "" {
+		srv.setupAdmin(conf.Server.AdminAddress, newAdminRouter(metrics, started))
+	}
 	return srv.start()
 }
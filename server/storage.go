@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/s1moe2/gosrv/config"
+	"github.com/s1moe2/gosrv/db"
+	"github.com/s1moe2/gosrv/models"
+	"github.com/s1moe2/gosrv/repositories"
+	"log/slog"
+)
+
+// storage holds the repositories of the configured database driver
+type storage struct {
+	userRepo models.UserRepository
+	// tx is nil when the driver doesn't support transactions
+	tx models.TxRunner
+	// db is nil when the driver doesn't use a database connection pool
+	db *sqlx.DB
+	// close releases the storage resources once the server has stopped
+	close func() error
+}
+
+// newStorage sets up the repositories for the configured database driver.
+// The memory driver keeps everything in memory, loading from and saving to
+// the JSON snapshot file given as URI, if any.
+func newStorage(dbConfig config.DatabaseConfig) (*storage, error) {
+	switch dbConfig.Driver {
+	case "memory":
+		repo := repositories.NewMemoryUserRepo()
+		if dbConfig.URI == "" {
+			return &storage{userRepo: repo, close: func() error { return nil }}, nil
+		}
+
+		if err := repo.LoadFile(dbConfig.URI); err != nil {
+			return nil, fmt.Errorf("failed to load memory snapshot: %s", err)
+		}
+		return &storage{
+			userRepo: repo,
+			close: func() error {
+				slog.Info("saving memory snapshot", "path", dbConfig.URI)
+				return repo.SaveFile(dbConfig.URI)
+			},
+		}, nil
+
+	default:
+		dbConn, err := db.ConnectDB(dbConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		if dbConfig.AutoMigrate {
+			if err := autoMigrate(dbConn, dbConfig.Driver); err != nil {
+				dbConn.Close()
+				return nil, err
+			}
+		}
+
+		return &storage{
+			userRepo: repositories.NewUserRepo(dbConn),
+			tx:       repositories.NewTxManager(dbConn),
+			db:       dbConn,
+			close:    dbConn.Close,
+		}, nil
+	}
+}
+
+// autoMigrate applies the pending migrations of a database driver
+func autoMigrate(dbConn *sqlx.DB, driver string) error {
+	migrator, err := newMigrator(dbConn, driver)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("applying pending migrations")
+	if err := migrator.Up(context.Background(), 0); err != nil {
+		return fmt.Errorf("failed to migrate: %s", err)
+	}
+	return nil
+}
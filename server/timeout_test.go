@@ -0,0 +1,50 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/s1moe2/gosrv/problem"
+)
+
+func TestTimeoutHandler(t *testing.T) {
+	handler := timeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			<-r.Context().Done()
+			w.Write([]byte("late"))
+		case "/panic":
+			panic("boom")
+		default:
+			w.Header().Set("X-Test", "1")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("done"))
+		}
+	}), 20*time.Millisecond, http.StatusGatewayTimeout)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	if w.Code != http.StatusCreated || w.Header().Get("X-Test") != "1" || w.Body.String() != "done" {
+		t.Fatalf("expected the buffered response, got %d %v %q", w.Code, w.Header(), w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if w.Code != http.StatusGatewayTimeout || w.Header().Get("Content-Type") != problem.ContentType {
+		t.Fatalf("expected a 504 problem, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var body problem.Problem
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body.Instance != "/slow" {
+		t.Fatalf("unexpected problem %+v (%v)", body, err)
+	}
+
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Fatalf("expected the panic to be propagated, got %v", p)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/panic", nil))
+}
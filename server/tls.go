@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/s1moe2/gosrv/config"
+	"github.com/s1moe2/gosrv/reqctx"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig returns the TLS configuration of the server, along with the reloader of its
+// certificate, or nil when no certificate is configured and the server speaks plain HTTP
+func newTLSConfig(tlsConfig config.TLSConfig) (*tls.Config, *certReloader, error) {
+	if tlsConfig.CertFile == "" && tlsConfig.KeyFile == "" {
+		if tlsConfig.ClientCAFile != "" {
+			return nil, nil, errors.New("tls: a client CA requires a certificate and key")
+		}
+		return nil, nil, nil
+	}
+	if tlsConfig.CertFile == "" || tlsConfig.KeyFile == "" {
+		return nil, nil, errors.New("tls: both a certificate and a key file are required")
+	}
+
+	version, ok := tlsVersions[tlsConfig.MinVersion]
+	if !ok {
+		return nil, nil, fmt.Errorf("tls: invalid minimum version %q", tlsConfig.MinVersion)
+	}
+	suites, err := cipherSuites(tlsConfig.CipherSuites)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certs, err := newCertReloader(tlsConfig.CertFile, tlsConfig.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tc := &tls.Config{
+		MinVersion:     version,
+		CipherSuites:   suites,
+		GetCertificate: certs.getCertificate,
+	}
+	if tlsConfig.ClientCAFile != "" {
+		pem, err := os.ReadFile(tlsConfig.ClientCAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tls: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("tls: no certificate found in client CA file %s", tlsConfig.ClientCAFile)
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tc, certs, nil
+}
+
+// cipherSuites returns the IDs of cipher suites given by name. Only the suites Go considers
+// secure are accepted.
+func cipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	byName := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		byName[s.Name] = s.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("tls: unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// certReloader serves a certificate loaded from files, loading it again when they change
+// so that it can be renewed without restarting the server
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	// stamp identifies the version of the files the certificate was loaded from
+	stamp string
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// getCertificate returns the certificate to present, as tls.Config.GetCertificate
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// fileStamp returns the modification time and size of the certificate and key files
+func (cr *certReloader) fileStamp() (string, error) {
+	var stamp string
+	for _, name := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return "", fmt.Errorf("tls: %w", err)
+		}
+		stamp += fmt.Sprintf("%d:%d;", info.ModTime().UnixNano(), info.Size())
+	}
+	return stamp, nil
+}
+
+// reload loads the certificate again if its files changed, reporting whether they did.
+// On failure, the certificate loaded before is kept.
+func (cr *certReloader) reload() (bool, error) {
+	stamp, err := cr.fileStamp()
+	if err != nil {
+		return false, err
+	}
+
+	cr.mu.RLock()
+	unchanged := stamp == cr.stamp
+	cr.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("tls: %w", err)
+	}
+
+	cr.mu.Lock()
+	cr.cert, cr.stamp = &cert, stamp
+	cr.mu.Unlock()
+	return true, nil
+}
+
+// watch checks the certificate files for changes at every interval, until ctx is done
+func (cr *certReloader) watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := cr.reload()
+			if err != nil {
+				slog.Error("failed to reload TLS certificate, keeping the current one", "error", err)
+			} else if changed {
+				slog.Info("TLS certificate reloaded", "cert_file", cr.certFile)
+			}
+		}
+	}
+}
+
+// clientIdentityMiddleware carries the identity of clients authenticated with a verified
+// certificate in the request context
+func clientIdentityMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cert := r.TLS.VerifiedChains[0][0]
+		id := reqctx.ClientIdentity{
+			CommonName:     cert.Subject.CommonName,
+			Organization:   cert.Subject.Organization,
+			DNSNames:       cert.DNSNames,
+			EmailAddresses: cert.EmailAddresses,
+			SerialNumber:   cert.SerialNumber.String(),
+		}
+		for _, uri := range cert.URIs {
+			id.URIs = append(id.URIs, uri.String())
+		}
+		next.ServeHTTP(w, r.WithContext(reqctx.WithClientIdentity(r.Context(), id)))
+	})
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/s1moe2/gosrv/config"
+	"github.com/s1moe2/gosrv/reqctx"
+)
+
+// testCert is a certificate along with its key, signed by parent or self-signed
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func newTestCA(t *testing.T) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+}
+
+// write writes the certificate and key as PEM files in dir, returning their paths
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "gosrv")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := tempDir(t)
+	ca := newTestCA(t)
+	certFile, keyFile := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "localhost"}}, ca).write(t, dir, "server")
+	caFile, _ := ca.write(t, dir, "ca")
+
+	if tc, _, err := newTLSConfig(config.TLSConfig{MinVersion: "1.2"}); tc != nil || err != nil {
+		t.Fatalf("expected no TLS without a certificate, got %v, %v", tc, err)
+	}
+
+	tc, _, err := newTLSConfig(config.TLSConfig{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		MinVersion:   "1.3",
+		CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+		ClientCAFile: caFile,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tc.MinVersion != tls.VersionTLS13 || len(tc.CipherSuites) != 1 || tc.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("unexpected TLS configuration %+v", tc)
+	}
+
+	invalid := map[string]config.TLSConfig{
+		"missing key":      {CertFile: certFile, MinVersion: "1.2"},
+		"CA without cert":  {ClientCAFile: caFile, MinVersion: "1.2"},
+		"insecure cipher":  {CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2", CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		"empty CA bundle":  {CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2", ClientCAFile: keyFile},
+		"unreadable files": {CertFile: certFile + ".missing", KeyFile: keyFile, MinVersion: "1.2"},
+	}
+	for name, c := range invalid {
+		if _, _, err := newTLSConfig(c); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := tempDir(t)
+	ca := newTestCA(t)
+	first := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "first"}}, ca)
+	certFile, keyFile := first.write(t, dir, "server")
+
+	cr, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changed, err := cr.reload(); changed || err != nil {
+		t.Fatalf("expected no reload of unchanged files, got %v, %v", changed, err)
+	}
+
+	second := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "second"}}, ca)
+	second.write(t, dir, "server")
+	// make the change visible even on file systems with coarse modification times
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+
+	if changed, err := cr.reload(); !changed || err != nil {
+		t.Fatalf("expected the certificate to be reloaded, got %v, %v", changed, err)
+	}
+	cert, _ := cr.getCertificate(nil)
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	if leaf.Subject.CommonName != "second" {
+		t.Fatalf("expected the new certificate, got %s", leaf.Subject.CommonName)
+	}
+
+	ioutil.WriteFile(keyFile, []byte("garbage"), 0600)
+	if _, err := cr.reload(); err == nil {
+		t.Fatal("expected an invalid key to fail the reload")
+	}
+	if current, _ := cr.getCertificate(nil); current != cert {
+		t.Fatal("expected a failed reload to keep the current certificate")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := tempDir(t)
+	ca := newTestCA(t)
+	certFile, keyFile := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca).write(t, dir, "server")
+	caFile, _ := ca.write(t, dir, "ca")
+
+	tc, _, err := newTLSConfig(config.TLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2", ClientCAFile: caFile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var identity reqctx.ClientIdentity
+	srv := httptest.NewUnstartedServer(clientIdentityMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ = reqctx.ClientIdentityFrom(r.Context())
+	})))
+	srv.TLS = tc
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientCert := newTestCert(t, &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "billing", Organization: []string{"acme"}},
+		EmailAddresses: []string{"billing@acme.example"},
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+	url := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	if _, err := client.Get(url); err == nil {
+		t.Fatal("expected clients without a certificate to be rejected")
+	}
+
+	client.Transport = &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{{Certificate: [][]byte{clientCert.der}, PrivateKey: clientCert.key}},
+	}}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if identity.CommonName != "billing" || identity.Organization[0] != "acme" || identity.EmailAddresses[0] != "billing@acme.example" {
+		t.Fatalf("unexpected client identity %+v", identity)
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/s1moe2/gosrv/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/s1moe2/gosrv/server")
+
+// traceExporter creates a span exporter, returning a function releasing what it opened
+type traceExporter func(ctx context.Context, tracingConfig config.TracingConfig) (sdktrace.SpanExporter, func() error, error)
+
+// traceExporters are the span exporters that can be configured, by name. The OTLP exporter
+// is configured through the standard OTEL_EXPORTER_OTLP_* environment variables.
+var traceExporters = map[string]traceExporter{
+	"stdout": func(ctx context.Context, tracingConfig config.TracingConfig) (sdktrace.SpanExporter, func() error, error) {
+		if tracingConfig.File == "" {
+			exporter, err := stdouttrace.New()
+			return exporter, func() error { return nil }, err
+		}
+
+		file, err := os.OpenFile(tracingConfig.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		return exporter, file.Close, err
+	},
+	"otlp": func(ctx context.Context, tracingConfig config.TracingConfig) (sdktrace.SpanExporter, func() error, error) {
+		exporter, err := otlptracehttp.New(ctx)
+		return exporter, func() error { return nil }, err
+	},
+}
+
+// setupTracing installs the global tracer provider for the configured exporter and the W3C
+// trace context propagator. The returned function flushes pending spans and releases the exporter.
+func setupTracing(ctx context.Context, tracingConfig config.TracingConfig) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if tracingConfig.Exporter == "none" || tracingConfig.Exporter == "" {
+		return func(ctx context.Context) error { return nil }, nil
+	}
+
+	newExporter, ok := traceExporters[tracingConfig.Exporter]
+	if !ok {
+		return nil, fmt.Errorf("invalid trace exporter %q", tracingConfig.Exporter)
+	}
+	exporter, closeExporter, err := newExporter(ctx, tracingConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %s", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName("gosrv")))
+	if err != nil {
+		return nil, err
+	}
+	// variables such as OTEL_SERVICE_NAME override the defaults above
+	res, err = resource.Merge(res, resource.Environment())
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closeErr := closeExporter(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
+
+// tracingMiddleware starts a server span for every request, continuing the trace of
+// an incoming traceparent header, and names it after the route template once known
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = withMatchedRoute(r)
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		// outside the router, the route is only known once the request has been served
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if route := routeTemplate(r); route != "" {
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/s1moe2/gosrv/config"
+	"github.com/s1moe2/gosrv/handlers"
+	"github.com/s1moe2/gosrv/repositories"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	uh := handlers.NewUsersHandler(repositories.NewMemoryUserRepo())
+	router := mux.NewRouter()
+	router.Use(tracingMiddleware)
+	router.Handle("/users/{id}", handlers.HandlerFunc(uh.GetByID))
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected a handler and a server span, got %d", len(spans))
+	}
+	handler, server := spans[0], spans[1]
+
+	if server.Name() != "GET /users/{id}" {
+		t.Fatalf("expected the server span to be named after the route, got %q", server.Name())
+	}
+	if server.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" ||
+		server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatal("expected the server span to continue the incoming trace")
+	}
+	if handler.Name() != "UsersHandler.GetByID" || handler.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Fatalf("expected a UsersHandler.GetByID child span, got %q", handler.Name())
+	}
+}
+
+func TestSetupTracing(t *testing.T) {
+	if _, err := setupTracing(context.Background(), config.TracingConfig{Exporter: "zipkin"}); err == nil {
+		t.Fatal("expected an error for an unknown exporter")
+	}
+
+	dir, err := ioutil.TempDir("", "gosrv")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "traces.json")
+
+	shutdown, err := setupTracing(context.Background(), config.TracingConfig{Exporter: "stdout", File: path})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, span := otel.Tracer("test").Start(context.Background(), "exported")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	exported, err := ioutil.ReadFile(path)
+	if err != nil || !strings.Contains(string(exported), `"Name":"exported"`) {
+		t.Fatalf("expected the span to be exported to the file, got %q (%v)", exported, err)
+	}
+}
@@ -0,0 +1,204 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// builtinRules are the rules every Validator starts with:
+//   - required: the field must not be a zero value
+//   - min=n, max=n: strings must have at least or at most n characters, slices and maps
+//     n items, and numbers must be at least or at most n
+//   - email: the field must be an email address
+//   - oneof=a b c: the field must be one of the values separated by spaces
+//   - eqfield=F, nefield=F: the field must be equal to, or differ from, the field F
+//   - required_with=F: the field is required when the field F is not a zero value
+var builtinRules = map[string]Rule{
+	"required":      required,
+	"min":           bound("min"),
+	"max":           bound("max"),
+	"email":         email,
+	"oneof":         oneOf,
+	"eqfield":       compareField(true),
+	"nefield":       compareField(false),
+	"required_with": requiredWith,
+}
+
+// emailRegexp matches email addresses, as the HTML specification defines them
+var emailRegexp = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+func required(Spec) (Check, error) {
+	return func(f Field) error {
+		if f.Value.IsZero() {
+			return errors.New("is required")
+		}
+		return nil
+	}, nil
+}
+
+// bound returns the min or max rule
+func bound(name string) Rule {
+	isMin := name == "min"
+	return func(spec Spec) (Check, error) {
+		limit, err := strconv.ParseFloat(spec.Param, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid limit %q", spec.Param)
+		}
+		outside := func(n float64) bool {
+			if isMin {
+				return n < limit
+			}
+			return n > limit
+		}
+		word := "most"
+		if isMin {
+			word = "least"
+		}
+
+		kind := spec.Field.Type.Kind()
+		if kind == reflect.Ptr {
+			kind = spec.Field.Type.Elem().Kind()
+		}
+		switch kind {
+		case reflect.String:
+			return func(f Field) error {
+				v := reflect.Indirect(f.Value)
+				if v.IsValid() && v.Len() > 0 && outside(float64(utf8.RuneCountInString(v.String()))) {
+					return fmt.Errorf("must be at %s %s characters long", word, spec.Param)
+				}
+				return nil
+			}, nil
+		case reflect.Slice, reflect.Map, reflect.Array:
+			return func(f Field) error {
+				v := reflect.Indirect(f.Value)
+				if v.IsValid() && v.Len() > 0 && outside(float64(v.Len())) {
+					return fmt.Errorf("must have at %s %s items", word, spec.Param)
+				}
+				return nil
+			}, nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return func(f Field) error {
+				v := reflect.Indirect(f.Value)
+				if v.IsValid() && outside(float64(v.Int())) {
+					return fmt.Errorf("must be at %s %s", word, spec.Param)
+				}
+				return nil
+			}, nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return func(f Field) error {
+				v := reflect.Indirect(f.Value)
+				if v.IsValid() && outside(float64(v.Uint())) {
+					return fmt.Errorf("must be at %s %s", word, spec.Param)
+				}
+				return nil
+			}, nil
+		case reflect.Float32, reflect.Float64:
+			return func(f Field) error {
+				v := reflect.Indirect(f.Value)
+				if v.IsValid() && outside(v.Float()) {
+					return fmt.Errorf("must be at %s %s", word, spec.Param)
+				}
+				return nil
+			}, nil
+		default:
+			return nil, fmt.Errorf("can't apply to %s", spec.Field.Type)
+		}
+	}
+}
+
+func email(spec Spec) (Check, error) {
+	if err := expectString(spec); err != nil {
+		return nil, err
+	}
+	return func(f Field) error {
+		v := reflect.Indirect(f.Value)
+		if v.IsValid() && v.Len() > 0 && !emailRegexp.MatchString(v.String()) {
+			return errors.New("must be an email address")
+		}
+		return nil
+	}, nil
+}
+
+func oneOf(spec Spec) (Check, error) {
+	if err := expectString(spec); err != nil {
+		return nil, err
+	}
+	values := strings.Fields(spec.Param)
+	if len(values) == 0 {
+		return nil, errors.New("no values")
+	}
+	allowed := map[string]bool{}
+	for _, value := range values {
+		allowed[value] = true
+	}
+
+	return func(f Field) error {
+		v := reflect.Indirect(f.Value)
+		if v.IsValid() && v.Len() > 0 && !allowed[v.String()] {
+			return fmt.Errorf("must be one of %s", strings.Join(values, ", "))
+		}
+		return nil
+	}, nil
+}
+
+// compareField returns the eqfield or nefield rule
+func compareField(equal bool) Rule {
+	return func(spec Spec) (Check, error) {
+		other, err := siblingField(spec)
+		if err != nil {
+			return nil, err
+		}
+		name := jsonName(other)
+
+		return func(f Field) error {
+			same := reflect.DeepEqual(f.Value.Interface(), f.Parent.FieldByIndex(other.Index).Interface())
+			switch {
+			case equal && !same:
+				return fmt.Errorf("must match %s", name)
+			case !equal && same && !f.Value.IsZero():
+				return fmt.Errorf("must differ from %s", name)
+			}
+			return nil
+		}, nil
+	}
+}
+
+func requiredWith(spec Spec) (Check, error) {
+	other, err := siblingField(spec)
+	if err != nil {
+		return nil, err
+	}
+	name := jsonName(other)
+
+	return func(f Field) error {
+		if f.Value.IsZero() && !f.Parent.FieldByIndex(other.Index).IsZero() {
+			return fmt.Errorf("is required with %s", name)
+		}
+		return nil
+	}, nil
+}
+
+// siblingField returns the field of the struct named by the parameter of a cross-field rule
+func siblingField(spec Spec) (reflect.StructField, error) {
+	other, ok := spec.Struct.FieldByName(spec.Param)
+	if !ok {
+		return reflect.StructField{}, fmt.Errorf("no field %q", spec.Param)
+	}
+	return other, nil
+}
+
+func expectString(spec Spec) error {
+	t := spec.Field.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.String {
+		return fmt.Errorf("can't apply to %s", spec.Field.Type)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+// Package validation validates structs against rules declared in their field tags:
+//
+//	type UserPayload struct {
+//		Name  string `json:"name" validate:"required,min=3,max=100"`
+//		Email string `json:"email" validate:"required,email,max=254"`
+//	}
+//
+// Rules are separated by commas and take an optional parameter after an equal sign.
+// Fields are named in errors after their JSON name. The rules of a struct type are
+// compiled once, when it is first validated, so that validating adds no parsing.
+//
+// Besides the built-in rules, custom ones can be registered with RegisterRule. Rules
+// receive the struct holding the field, so that they can compare it with other fields.
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// FieldError is a rule a field doesn't satisfy
+type FieldError struct {
+	// Field is the path of the field, after its JSON name, such as address.city
+	Field string
+	// Code identifies the failed rule, for machines, and is the name of the rule
+	Code string
+	// Message describes the failure, for humans
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// Errors lists the rules a struct doesn't satisfy
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return strings.Join(msgs, ", ")
+}
+
+// Field is a field being checked by a rule
+type Field struct {
+	Value reflect.Value
+	// Parent is the struct holding the field, for rules involving other fields
+	Parent reflect.Value
+}
+
+// Check checks a field, returning an error describing why it doesn't satisfy a rule, if it
+// doesn't. Checks are called for zero values too; most rules are satisfied by them, leaving
+// it to the required rule to reject them.
+type Check func(f Field) error
+
+// Spec is what a rule is compiled from: its parameter and the field it applies to
+type Spec struct {
+	Param  string
+	Field  reflect.StructField
+	Struct reflect.Type
+}
+
+// Rule compiles the Check of a rule, once for each field it is declared on. It fails when
+// the rule can't apply to the field, such as for an invalid parameter.
+type Rule func(spec Spec) (Check, error)
+
+// Validator validates structs with a set of rules
+type Validator struct {
+	mu    sync.RWMutex
+	rules map[string]Rule
+	// plans caches the compiled rules of each struct type
+	plans sync.Map
+}
+
+// New returns a Validator with the built-in rules
+func New() *Validator {
+	v := &Validator{rules: map[string]Rule{}}
+	for name, rule := range builtinRules {
+		v.rules[name] = rule
+	}
+	return v
+}
+
+// RegisterRule adds a rule, or replaces the one with the same name. Rules should be
+// registered before validating any struct using them.
+func (v *Validator) RegisterRule(name string, rule Rule) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.rules[name] = rule
+	v.plans.Range(func(t, _ interface{}) bool {
+		v.plans.Delete(t)
+		return true
+	})
+}
+
+// Struct validates a struct, or a pointer to one, returning the rules it doesn't satisfy,
+// or nil if it satisfies them all. It panics if the rules of the struct are invalid, which
+// is a programming error.
+func (v *Validator) Struct(s interface{}) Errors {
+	value := reflect.Indirect(reflect.ValueOf(s))
+	if value.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validation: expected a struct, got %T", s))
+	}
+
+	var errs Errors
+	v.validate(value, "", &errs)
+	return errs
+}
+
+// fieldPlan holds the compiled rules of a struct field
+type fieldPlan struct {
+	index  int
+	name   string
+	rules  []compiledRule
+	nested bool
+}
+
+type compiledRule struct {
+	name  string
+	check Check
+}
+
+func (v *Validator) validate(value reflect.Value, prefix string, errs *Errors) {
+	for _, fp := range v.plan(value.Type()) {
+		fv := value.Field(fp.index)
+		name := prefix + fp.name
+
+		failed := false
+		for _, rule := range fp.rules {
+			if err := rule.check(Field{Value: fv, Parent: value}); err != nil {
+				*errs = append(*errs, &FieldError{Field: name, Code: rule.name, Message: err.Error()})
+				failed = true
+				break
+			}
+		}
+
+		if fp.nested && !failed {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			v.validate(fv, name+".", errs)
+		}
+	}
+}
+
+// plan returns the compiled rules of a struct type, compiling them on first use
+func (v *Validator) plan(t reflect.Type) []fieldPlan {
+	if plan, ok := v.plans.Load(t); ok {
+		return plan.([]fieldPlan)
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	var plan []fieldPlan
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		fp := fieldPlan{index: i, name: jsonName(sf)}
+		if fp.name == "-" {
+			continue
+		}
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		fp.nested = ft.Kind() == reflect.Struct && sf.Tag.Get("validate") != "-"
+
+		for _, def := range splitRules(sf.Tag.Get("validate")) {
+			name, param, _ := strings.Cut(def, "=")
+			rule, ok := v.rules[name]
+			if !ok {
+				panic(fmt.Sprintf("validation: %s.%s: unknown rule %q", t.Name(), sf.Name, name))
+			}
+			check, err := rule(Spec{Param: param, Field: sf, Struct: t})
+			if err != nil {
+				panic(fmt.Sprintf("validation: %s.%s: rule %s: %s", t.Name(), sf.Name, name, err))
+			}
+			fp.rules = append(fp.rules, compiledRule{name: name, check: check})
+		}
+
+		if len(fp.rules) > 0 || fp.nested {
+			plan = append(plan, fp)
+		}
+	}
+
+	v.plans.Store(t, plan)
+	return plan
+}
+
+func splitRules(tag string) []string {
+	if tag == "" || tag == "-" {
+		return nil
+	}
+	return strings.Split(tag, ",")
+}
+
+// jsonName returns the name of a field in JSON documents
+func jsonName(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+	if name == "" {
+		return sf.Name
+	}
+	return name
+}
+
+// Default is the Validator used by the package functions
+var Default = New()
+
+// Struct validates a struct with the Default validator
+func Struct(s interface{}) Errors {
+	return Default.Struct(s)
+}
+
+// RegisterRule adds a rule to the Default validator
+func RegisterRule(name string, rule Rule) {
+	Default.RegisterRule(name, rule)
+}
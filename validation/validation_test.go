@@ -0,0 +1,129 @@
+package validation
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type address struct {
+	City    string `json:"city" validate:"required"`
+	Country string `json:"country" validate:"oneof=PT ES"`
+}
+
+type account struct {
+	Name     string   `json:"name" validate:"required,min=3,max=5"`
+	Email    string   `json:"email,omitempty" validate:"email"`
+	Age      int      `json:"age" validate:"min=18"`
+	Tags     []string `json:"tags" validate:"max=2"`
+	Password string   `json:"password"`
+	Confirm  string   `json:"confirm" validate:"eqfield=Password"`
+	Backup   string   `json:"backup" validate:"nefield=Email"`
+	Phone    *string  `json:"phone" validate:"required_with=Address,min=9"`
+	Address  *address `json:"address"`
+	Ignored  string   `json:"-" validate:"required"`
+}
+
+func codes(errs Errors) map[string]string {
+	m := map[string]string{}
+	for _, fe := range errs {
+		m[fe.Field] = fe.Code
+	}
+	return m
+}
+
+func TestStruct(t *testing.T) {
+	phone := "12345"
+	cases := []struct {
+		name     string
+		account  account
+		expected map[string]string
+	}{
+		{"valid", account{Name: "João", Age: 18}, map[string]string{}},
+		{"required", account{Age: 18}, map[string]string{"name": "required"}},
+		{"min", account{Name: "Jo", Age: 17}, map[string]string{"name": "min", "age": "min"}},
+		{"max", account{Name: "Joanna", Tags: []string{"a", "b", "c"}, Age: 18}, map[string]string{"name": "max", "tags": "max"}},
+		{"email", account{Name: "João", Email: "nope", Age: 18}, map[string]string{"email": "email"}},
+		{"eqfield", account{Name: "João", Age: 18, Password: "secret", Confirm: "secrets"}, map[string]string{"confirm": "eqfield"}},
+		{"nefield", account{Name: "João", Age: 18, Email: "a@b.c", Backup: "a@b.c"}, map[string]string{"backup": "nefield"}},
+		{"required_with", account{Name: "João", Age: 18, Address: &address{City: "Porto"}}, map[string]string{"phone": "required_with"}},
+		{"pointer", account{Name: "João", Age: 18, Phone: &phone}, map[string]string{"phone": "min"}},
+		{"nested", account{Name: "João", Age: 18, Phone: &phone, Address: &address{Country: "FR"}},
+			map[string]string{"phone": "min", "address.city": "required", "address.country": "oneof"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			errs := Struct(&c.account)
+			if got := codes(errs); !reflect.DeepEqual(got, c.expected) {
+				t.Fatalf("expected failed rules %v, got %v", c.expected, errs)
+			}
+		})
+	}
+}
+
+func TestStruct_Messages(t *testing.T) {
+	errs := Struct(account{Name: "Jo", Email: "nope"})
+	expected := "name: must be at least 3 characters long, email: must be an email address, age: must be at least 18"
+	if errs.Error() != expected {
+		t.Fatalf("expected %q, got %q", expected, errs.Error())
+	}
+}
+
+func TestValidator_RegisterRule(t *testing.T) {
+	type payload struct {
+		Slug string `json:"slug" validate:"lowercase"`
+	}
+
+	compiled := 0
+	v := New()
+	v.RegisterRule("lowercase", func(Spec) (Check, error) {
+		compiled++
+		return func(f Field) error {
+			if s := f.Value.String(); s != strings.ToLower(s) {
+				return errors.New("must be lowercase")
+			}
+			return nil
+		}, nil
+	})
+
+	for i := 0; i < 3; i++ {
+		errs := v.Struct(payload{Slug: "Go"})
+		if len(errs) != 1 || errs[0].Code != "lowercase" || errs[0].Message != "must be lowercase" {
+			t.Fatalf("expected the custom rule to fail, got %v", errs)
+		}
+	}
+	if compiled != 1 {
+		t.Fatalf("expected the rules to be compiled once, got %d", compiled)
+	}
+	if errs := v.Struct(payload{Slug: "go"}); errs != nil {
+		t.Fatalf("expected no errors, got %v", errs)
+	}
+}
+
+func TestStruct_InvalidRules(t *testing.T) {
+	cases := map[string]interface{}{
+		"unknown rule": struct {
+			A string `validate:"nope"`
+		}{},
+		"invalid limit": struct {
+			A string `validate:"min=x"`
+		}{},
+		"unknown field": struct {
+			A string `validate:"eqfield=B"`
+		}{},
+		"unsupported type": struct {
+			A int `validate:"email"`
+		}{},
+	}
+	for name, s := range cases {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("expected a panic")
+				}
+			}()
+			Struct(s)
+		})
+	}
+}